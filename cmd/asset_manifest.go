@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-vgo/robotgo"
+)
+
+const (
+	faceappAssetsDir  = "./assets/faceapp"
+	assetManifestFile = "manifest.json"
+
+	// Images without a minimum confidence of their own, or of their element, are accepted from this confidence.
+	assetDefaultMinConfidence = 0.5
+)
+
+// AssetImage is one picture of a UI element. MinConfidence overrides the element's minimum for this image.
+type AssetImage struct {
+	File          string  `json:"file"`
+	MinConfidence float32 `json:"minConfidence,omitempty"`
+}
+
+// AssetRegion is the part of the BlueStacks window an element is expected in, as fractions of the window size.
+type AssetRegion struct {
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// AssetElement is a logical UI element. Its images are alternates, tried in order until one is found with enough confidence.
+// Marker elements are only shown on their screen state, so finding one names the screen.
+type AssetElement struct {
+	Name          string       `json:"name"`
+	Images        []AssetImage `json:"images"`
+	MinConfidence float32      `json:"minConfidence,omitempty"`
+	Region        *AssetRegion `json:"region,omitempty"`
+	State         ScreenState  `json:"state,omitempty"`
+	Marker        bool         `json:"marker,omitempty"`
+}
+
+// AssetManifest describes the UI elements of an asset pack, so that code asks for elements by name rather than by file.
+type AssetManifest struct {
+	Dir      string          `json:"-"`
+	Elements []*AssetElement `json:"elements"`
+
+	byName map[string]*AssetElement
+}
+
+// Load and validate the manifest.json of an asset pack.
+func LoadAssetManifest(assetsDir string) (*AssetManifest, error) {
+	manifestPath := path.Join(assetsDir, assetManifestFile)
+	file, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &AssetManifest{}
+	err = json.Unmarshal(file, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, manifestPath)
+	}
+	manifest.Dir = assetsDir
+	err = manifest.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, manifestPath)
+	}
+	return manifest, nil
+}
+
+func (m *AssetManifest) validate() error {
+	knownStates := map[ScreenState]bool{}
+	for _, definition := range screenStates {
+		knownStates[definition.State] = true
+	}
+	m.byName = map[string]*AssetElement{}
+	for _, element := range m.Elements {
+		if element.Name == "" {
+			return errors.New("Asset element has no name")
+		}
+		if m.byName[element.Name] != nil {
+			return fmt.Errorf("Asset element %v is listed more than once", element.Name)
+		}
+		m.byName[element.Name] = element
+		if len(element.Images) == 0 {
+			return fmt.Errorf("Asset element %v has no images", element.Name)
+		}
+		if element.MinConfidence < 0 || element.MinConfidence > 1 {
+			return fmt.Errorf("Asset element %v has a minimum confidence outside of 0 to 1", element.Name)
+		}
+		for _, img := range element.Images {
+			if img.MinConfidence < 0 || img.MinConfidence > 1 {
+				return fmt.Errorf("Asset element %v image %v has a minimum confidence outside of 0 to 1", element.Name, img.File)
+			}
+			if _, err := os.Stat(m.ImagePath(img)); err != nil {
+				return fmt.Errorf("Asset element %v - %v", element.Name, err)
+			}
+		}
+		if r := element.Region; r != nil {
+			if r.Left < 0 || r.Top < 0 || r.Width <= 0 || r.Height <= 0 || r.Left+r.Width > 1 || r.Top+r.Height > 1 {
+				return fmt.Errorf("Asset element %v has a region outside of the window", element.Name)
+			}
+		}
+		if element.State != "" && !knownStates[element.State] {
+			return fmt.Errorf("Asset element %v belongs to unknown screen state %v", element.Name, element.State)
+		}
+		if element.Marker && element.State == "" {
+			return fmt.Errorf("Asset element %v is a marker without a screen state", element.Name)
+		}
+	}
+	return nil
+}
+
+func (m *AssetManifest) Element(name string) (*AssetElement, error) {
+	element, found := m.byName[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown asset element %v", name)
+	}
+	return element, nil
+}
+
+// The marker elements of a screen state, in manifest order.
+func (m *AssetManifest) Markers(state ScreenState) []*AssetElement {
+	var markers []*AssetElement
+	for _, element := range m.Elements {
+		if element.Marker && element.State == state {
+			markers = append(markers, element)
+		}
+	}
+	return markers
+}
+
+// Decode the first image of an element, such as to measure its size.
+func (m *AssetManifest) DecodeImage(name string) (image.Image, error) {
+	element, err := m.Element(name)
+	if err != nil {
+		return nil, err
+	}
+	imagePath := m.ImagePath(element.Images[0])
+	img, _, err := robotgo.DecodeImg(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, imagePath)
+	}
+	return img, nil
+}
+
+func (m *AssetManifest) ImagePath(img AssetImage) string {
+	return path.Join(m.Dir, img.File)
+}
+
+func (e *AssetElement) minConfidence(img AssetImage) float32 {
+	if img.MinConfidence > 0 {
+		return img.MinConfidence
+	}
+	if e.MinConfidence > 0 {
+		return e.MinConfidence
+	}
+	return assetDefaultMinConfidence
+}
+
+// Element names follow the asset file names, eg. enhancement-beards and etype-beards-full-beard.
+func assetSlug(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+}
+
+func enhancementElement(enhancementName string) string {
+	return "enhancement-" + assetSlug(enhancementName)
+}
+
+func enhancementTypeElement(enhancementName, typeName string) string {
+	return fmt.Sprintf("etype-%s-%s", assetSlug(enhancementName), assetSlug(typeName))
+}
+
+func (b *BlueStacks) LoadAssets(assetsDir string) error {
+	manifest, err := LoadAssetManifest(assetsDir)
+	if err != nil {
+		return err
+	}
+	b.Assets = manifest
+	return nil
+}
+
+// BlueStacks values built without LoadAssets load the FaceApp asset pack on first use.
+func (b *BlueStacks) assets() *AssetManifest {
+	if b.Assets == nil {
+		err := b.LoadAssets(faceappAssetsDir)
+		if err != nil {
+			log.Fatal("ERROR: ", err.Error())
+		}
+	}
+	return b.Assets
+}
+
+// The pixels of a screenshot covered by a region of the window.
+func (b *BlueStacks) regionInImage(region AssetRegion, screenImg image.Image) image.Rectangle {
+	space := b.coordSpace()
+	min := space.ToImage(space.WindowToPointer(region.Left, region.Top), screenImg)
+	max := space.ToImage(space.WindowToPointer(region.Left+region.Width, region.Top+region.Height), screenImg)
+	return image.Rectangle{Min: min, Max: max}.Intersect(screenImg.Bounds())
+}
+
+// Find a UI element on screen by name. Returns the coordinates and confidence of the first of its images found with enough confidence.
+func (b *BlueStacks) Locate(name string, screenImg image.Image) (Coords, float32, error) {
+	coords, _, confidence, err := b.locate(name, screenImg, 0)
+	return coords, confidence, err
+}
+
+// Find a UI element on screen by name with the coordinates cache.
+func (b *BlueStacks) LocateWithCache(name string, screenImg image.Image, cacheKey string) (Coords, error) {
+	return b.GetTemplateCoordsWithCache(func() (Coords, string, error) {
+		coords, imagePath, _, err := b.locate(name, screenImg, 0)
+		return coords, imagePath, err
+	}, cacheKey, screenImg)
+}
+
+// Search for each image of an element within its region, stopping at the first image found with at least its minimum confidence, or minConfidence if that is higher.
+// Returns the path of the image that was found along with its coordinates.
+func (b *BlueStacks) locate(name string, screenImg image.Image, minConfidence float32) (Coords, string, float32, error) {
+	manifest := b.assets()
+	element, err := manifest.Element(name)
+	if err != nil {
+		return Coords{}, "", 0, err
+	}
+
+	err = errors.New("No images")
+	for _, img := range element.Images {
+		imagePath := manifest.ImagePath(img)
+		threshold := element.minConfidence(img)
+		if minConfidence > threshold {
+			threshold = minConfidence
+		}
+		var coords Coords
+		var confidence float32
+		coords, confidence, err = b.locateImage(imagePath, screenImg, element.Region, threshold)
+		decision := SessionDecision{
+			Kind:          DecisionTemplate,
+			Element:       name,
+			Template:      imagePath,
+			Region:        element.Region,
+			MinConfidence: threshold,
+			Coords:        coords,
+			Confidence:    confidence,
+		}
+		if err != nil {
+			decision.Error = err.Error()
+			err = fmt.Errorf("%v: %s", err, imagePath)
+		}
+		b.recordDecision(screenImg, decision)
+		if err == nil {
+			if debugMode {
+				log.Printf("DEBUG: Found %v with %v - coords: %v , confidence: %v\n", name, imagePath, coords, confidence)
+			}
+			return coords, imagePath, confidence, nil
+		}
+	}
+	return Coords{}, "", 0, fmt.Errorf("Cannot locate %v - %v", name, err)
+}
+
+// Search for a single image of an element within a region of the window, or the whole screen when region is nil.
+// The best match is returned along with an error when it is below minConfidence, which replays use to repeat a recorded search exactly.
+func (b *BlueStacks) locateImage(imagePath string, screenImg image.Image, region *AssetRegion, minConfidence float32) (Coords, float32, error) {
+	searchArea := screenImg
+	offset := image.Point{}
+	if region != nil {
+		regionRect := b.regionInImage(*region, screenImg)
+		if regionRect.Empty() {
+			return Coords{}, 0, errors.New("Region is outside of the screen")
+		}
+		searchArea = imaging.Crop(screenImg, regionRect)
+		offset = regionRect.Min.Sub(screenImg.Bounds().Min)
+	}
+	searchImg, _, err := robotgo.DecodeImg(imagePath)
+	if err != nil {
+		return Coords{}, 0, err
+	}
+	point, confidence, err := b.findImageInImage(searchImg, searchArea, minConfidence)
+	if err != nil && confidence == 0 {
+		return Coords{}, 0, err
+	}
+	return b.GetCoords(point.X+offset.X, point.Y+offset.Y, screenImg), confidence, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestAssetManifest(t *testing.T) *AssetManifest {
+	cwd, _ := os.Getwd()
+	manifest, err := LoadAssetManifest(path.Join(cwd, "../", faceappAssetsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// A screenshot of noise, so that a template cut from it is only found where it is drawn.
+func noiseImage(seed int64, width, height int) *image.RGBA {
+	random := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		if i%4 == 3 {
+			img.Pix[i] = 255
+			continue
+		}
+		img.Pix[i] = uint8(random.Intn(256))
+	}
+	return img
+}
+
+func drawImage(dst *image.RGBA, src image.Image, at image.Point) {
+	draw.Draw(dst, src.Bounds().Add(at), src, image.Point{}, draw.Src)
+}
+
+func fillRect(dst *image.RGBA, rect image.Rectangle, c color.Color) {
+	draw.Draw(dst, rect, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// Write the images of an asset pack to a temporary directory as PNG, and load its manifest.
+func writeTestAssetPack(t *testing.T, images map[string]image.Image, manifestJson string) *AssetManifest {
+	dir := t.TempDir()
+	for file, img := range images {
+		f, err := os.Create(path.Join(dir, file))
+		if err == nil {
+			err = png.Encode(f, img)
+			f.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ioutil.WriteFile(path.Join(dir, assetManifestFile), []byte(manifestJson), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadAssetManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestAssetManifestCoversAssetPack(t *testing.T) {
+	manifest := loadTestAssetManifest(t)
+	listed := map[string]bool{}
+	for _, element := range manifest.Elements {
+		for _, img := range element.Images {
+			listed[img.File] = true
+		}
+	}
+	assets, _ := filepath.Glob(path.Join(manifest.Dir, "*.png"))
+	for _, asset := range assets {
+		if !listed[filepath.Base(asset)] {
+			t.Errorf("Asset %v is not listed in the manifest", filepath.Base(asset))
+		}
+	}
+
+	for _, enhancement := range enhancements {
+		if _, err := manifest.Element(enhancementElement(enhancement.Name)); err != nil {
+			t.Error(err)
+		}
+		for _, eType := range enhancement.Types {
+			if _, err := manifest.Element(enhancementTypeElement(enhancement.Name, eType.Name)); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	for _, definition := range screenStates {
+		// The save and throttled screens are only named once their markers are captured into the asset pack
+		if definition.State != StateSave && definition.State != StateThrottled && len(manifest.Markers(definition.State)) == 0 {
+			t.Errorf("Screen state %v has no markers", definition.State)
+		}
+	}
+}
+
+func TestAssetElementMinConfidence(t *testing.T) {
+	manifest := loadTestAssetManifest(t)
+	gallery, err := manifest.Element("gallery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gallery.Images) != 3 || gallery.minConfidence(gallery.Images[0]) != 0.9 || gallery.minConfidence(gallery.Images[2]) != assetDefaultMinConfidence {
+		t.Errorf("Unexpected gallery element %+v", gallery)
+	}
+	if _, err := manifest.Element("save-screen"); err == nil {
+		t.Error("Expected an unknown element to be an error")
+	}
+}
+
+func TestAssetManifestValidation(t *testing.T) {
+	dir := t.TempDir()
+	for name, manifestJson := range map[string]string{
+		"missing image":  `{"elements": [{"name": "apply", "images": [{"file": "apply.png"}]}]}`,
+		"duplicate name": `{"elements": [{"name": "a", "images": [{"file": "a.png"}]}, {"name": "a", "images": [{"file": "a.png"}]}]}`,
+		"bad region":     `{"elements": [{"name": "a", "images": [{"file": "a.png"}], "region": {"left": 0.5, "top": 0, "width": 0.6, "height": 1}}]}`,
+		"unknown state":  `{"elements": [{"name": "a", "images": [{"file": "a.png"}], "state": "settings"}]}`,
+		"marker":         `{"elements": [{"name": "a", "images": [{"file": "a.png"}], "marker": true}]}`,
+	} {
+		err := ioutil.WriteFile(path.Join(dir, "a.png"), []byte{}, 0644)
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(dir, assetManifestFile), []byte(manifestJson), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadAssetManifest(dir); err == nil {
+			t.Errorf("Expected the %v manifest to be invalid", name)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
 	cli "github.com/spf13/cobra"
 )
 
@@ -19,6 +20,7 @@ var (
 	beardsCmd = &cli.Command{
 		Use:   "beards",
 		Short: "Prepare beards directory",
+		Long:  "Copy the images that the rules of the Beards enhancement force a beard onto into a directory of their own. The Beards gender requirement applies too, so only faces analysed as Male are prepared, even if they have a beard or mustache.",
 		Run:   PrepareBeardsDirectory,
 	}
 )
@@ -29,6 +31,8 @@ func init() {
 	beardsCmd.PersistentFlags().StringP("output", "o", "./output/step2.1", "Path to local output directory.")
 	beardsCmd.PersistentFlags().StringP("source", "s", "", "Path to source image directories. Can be both enhanced image directory and original step2 directory.")
 	beardsCmd.PersistentFlags().StringP("facedata", "f", "", "Path to AWS Face Analysis dataset directory.")
+	beardsCmd.PersistentFlags().String("enhancements", defaultEnhancementCatalogueFile, "Path to the enhancement catalogue -- the enhancements and types that can be chosen, their probabilities and rules. Images are prepared by the rules of the Beards enhancement.")
+	beardsCmd.PersistentFlags().String("policy", defaultSafetyPolicyFile, "Path to the safety policy -- the minimum age, exclusion lists and face quality and pose limits of the images that can be selected.")
 	beardsCmd.PersistentFlags().IntP("limit", "l", 1000, "Limit on the number of images prepared.")
 
 	_ = beardsCmd.MarkFlagRequired("source")
@@ -39,6 +43,8 @@ func PrepareBeardsDirectory(cmd *cli.Command, args []string) {
 	sourceDir, _ := cmd.Flags().GetString("source")
 	facedataDir, _ := cmd.Flags().GetString("facedata")
 	limit, _ := cmd.Flags().GetInt("limit")
+	beards := catalogueEnhancementFlag(cmd, "Beards")
+	useSafetyPolicyFlag(cmd)
 
 	log.Println("Isolating images to apply beards...")
 
@@ -61,6 +67,7 @@ func PrepareBeardsDirectory(cmd *cli.Command, args []string) {
 
 	// Fetch the facedata details
 	count := 0
+	skipped := map[string]int{}
 	var processed []FaceById
 	for _, facedataPath := range facedataPaths {
 		if limit > 0 {
@@ -73,12 +80,19 @@ func PrepareBeardsDirectory(cmd *cli.Command, args []string) {
 		// Read the file and unmarshal the data
 		file, _ := ioutil.ReadFile(facedataPath)
 		_ = json.Unmarshal([]byte(file), &facedata)
-
-		faceDetails := facedata.FaceDetails[0]
+		if len(facedata.FaceDetails) == 0 {
+			log.Printf("WARN: No face analysis data in %v\n", facedataPath)
+			continue
+		}
 
 		name := getFileName(facedataPath)
 
-		if faceDetails.Beard.Value || faceDetails.Mustache.Value {
+		// Only images the safety policy allows are considered by the Beards rules
+		if reason := safetyPolicy.Check(name, facedata); reason != "" {
+			skipped[reason]++
+			continue
+		}
+		if preparesBeards(beards, facedata.FaceDetails[0]) {
 			// Move the file to the new directory.
 			processed = append(processed, FaceById{
 				Id:   name,
@@ -115,5 +129,11 @@ func PrepareBeardsDirectory(cmd *cli.Command, args []string) {
 		log.Printf("Successfully prepared image %v - %v", f.Id, outputFile)
 	}
 
-	log.Printf("%d [Count: %d] images prepared for beards enhancement!\n", len(processed), count)
+	log.Printf("%d [Count: %d] images prepared for beards enhancement! Skipped %v by the safety policy\n", len(processed), count, policySkipSummary(skipped))
+}
+
+// Whether the rules of the Beards enhancement force a beard onto a face -- one the gender requirement allows, with a beard or mustache by the default catalogue.
+func preparesBeards(beards Enhancement, faceDetails types.FaceDetail) bool {
+	outcome := beards.Evaluate(faceDetails)
+	return outcome.Eligible && outcome.Forced
 }
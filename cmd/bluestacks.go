@@ -6,14 +6,19 @@ import (
 	"image"
 	"log"
 	"math"
+	"sort"
 
 	"github.com/disintegration/imaging"
 	"github.com/go-vgo/robotgo"
 	"github.com/vcaesar/gcv"
+	"github.com/vcaesar/imgo"
 	"github.com/vitali-fedulov/images/v2"
 	"gocv.io/x/gocv"
 )
 
+// Template matching stops searching smaller scales once a match is at least this confident.
+const templateConfidentMatch = 0.9
+
 type Coords struct {
 	X int
 	Y int
@@ -26,12 +31,26 @@ type Bounds struct {
 	Height int
 }
 
+// ScreenWidth, ScreenHeight and CenterCoords describe the BlueStacks window in pointer coordinates, and are kept in step with Space by SetCoordSpace.
 type BlueStacks struct {
-	ActivePID      int32
-	ScreenWidth    int
-	ScreenHeight   int
-	CenterCoords   *Coords
-	FaceClassifier gocv.CascadeClassifier
+	Driver       ScreenDriver
+	ActivePID    int32
+	Space        CoordSpace
+	ScreenWidth  int
+	ScreenHeight int
+	CenterCoords *Coords
+	FaceDetector FaceDetector
+	CoordsCache  *CoordsCache
+	Assets       *AssetManifest
+}
+
+// ImageMatch is a single match of a search image on screen. Coords and Rect are in screen coordinates.
+// Scale is the size of the search image on screen relative to its original size.
+type ImageMatch struct {
+	Coords     Coords
+	Rect       image.Rectangle
+	Confidence float32
+	Scale      float64
 }
 
 type CVResult struct {
@@ -41,92 +60,66 @@ type CVResult struct {
 	SourceImage image.Image
 }
 
-var (
-	coordsCache = map[string]Coords{}
-)
-
-func init() {
-	robotgo.KeySleep = 100
-	robotgo.MouseSleep = 100
-}
-
-func NewBlueStacks() *BlueStacks {
-	processName := "BlueStacks"
-	fpid, err := robotgo.FindIds(processName)
-	if err != nil {
-		log.Fatal("ERROR:", err.Error())
-	}
-	if len(fpid) == 0 {
-		log.Fatal("ERROR: BlueStacks is not running.")
-	}
-	log.Println(fpid)
-	// Ensure that the main BlueStacks pid is used
-	var activePid int32
-	for _, pid := range fpid {
-		name, err := robotgo.FindName(pid)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if name == processName {
-			activePid = pid
-			break
-		}
-	}
-	err = robotgo.ActivePID(activePid)
+func NewBlueStacks(driver ScreenDriver) *BlueStacks {
+	activePid, err := driver.FocusProcess("BlueStacks")
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("ERROR: ", err.Error())
 	}
+	log.Println(activePid)
 
 	// windowX, windowY, windowWidth, windowHeight := robotgo.GetBounds(fpid[0])
 	//* Seems that GetBounds isn't returning the coordinates that are actually correct...
-	// Instead, we're simple going to use the screensize to set the mouse to the center of the screen.
-	screenWidth, screenHeight := robotgo.GetScreenSize()
-
+	// Instead, BlueStacks is assumed to fill the captured region -- the whole screen, or the window given to the driver.
 	bluestacks := &BlueStacks{
-		ActivePID:    activePid,
-		ScreenWidth:  screenWidth,
-		ScreenHeight: screenHeight,
-		CenterCoords: &Coords{
-			X: screenWidth / 2,
-			Y: screenHeight / 2,
-		},
+		Driver:    driver,
+		ActivePID: activePid,
 	}
+	bluestacks.SetCoordSpace(NewCoordSpace(driver.CaptureBounds()))
 
 	return bluestacks
 }
 
-func (b *BlueStacks) LoadFaceClassifier(cascadeFile string) error {
-	// load classifier to recognize faces
-	b.FaceClassifier = gocv.NewCascadeClassifier()
-
-	if !b.FaceClassifier.Load(cascadeFile) {
-		return fmt.Errorf("Error reading cascade file: %v\n", cascadeFile)
+// Set the coordinate space that screenshot pixels are converted through.
+func (b *BlueStacks) SetCoordSpace(space CoordSpace) {
+	b.Space = space
+	b.ScreenWidth = space.Window.Width
+	b.ScreenHeight = space.Window.Height
+	center := space.Center()
+	b.CenterCoords = &center
+	if recorder, ok := b.Driver.(DecisionRecorder); ok {
+		recorder.RecordCoordSpace(space)
 	}
+}
 
-	return nil
+// BlueStacks values built without a coordinate space treat the screen size as the whole captured region.
+func (b *BlueStacks) coordSpace() CoordSpace {
+	if b.Space.Capture.Width == 0 || b.Space.Capture.Height == 0 {
+		return NewCoordSpace(Bounds{Width: b.ScreenWidth, Height: b.ScreenHeight})
+	}
+	return b.Space
 }
 
 func (b *BlueStacks) MoveClick(x, y int) {
-	robotgo.Move(x, y)
-	robotgo.MilliSleep(300)
-	robotgo.Click()
+	b.Driver.Move(x, y)
+	b.Driver.MilliSleep(300)
+	b.Driver.Click()
 }
 
 func (b *BlueStacks) TypeStr(str string) {
 	for _, char := range str {
-		// robotgo.MilliSleep(20)
-		robotgo.KeyTap(string(char))
+		// b.Driver.MilliSleep(20)
+		b.Driver.KeyTap(string(char))
 	}
 }
 
 func (b *BlueStacks) scroll(scrollBy int) {
-	robotgo.Move(b.CenterCoords.X, b.CenterCoords.Y)
-	robotgo.MilliSleep(100)
+	b.Driver.Move(b.CenterCoords.X, b.CenterCoords.Y)
+	b.Driver.MilliSleep(100)
 	// If the scrollBy integer is small enough, Bluestacks will simply register the micro drag as a tap/click
 	if scrollBy < 50 {
 		scrollBy = 50
 	}
-	robotgo.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y+scrollBy)
+	b.Driver.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y+scrollBy)
 }
 
 func (b *BlueStacks) ScrollUp(scrollBy int) {
@@ -144,15 +137,15 @@ func (b *BlueStacks) canScroll(scrollBy int) bool {
 		scrollBy = 50
 	}
 
-	preImg := robotgo.CaptureImg()
-	robotgo.Move(b.CenterCoords.X, b.CenterCoords.Y)
-	robotgo.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y+scrollBy)
-	robotgo.MilliSleep(500)
-	postImg := robotgo.CaptureImg()
+	preImg := b.Driver.CaptureImg()
+	b.Driver.Move(b.CenterCoords.X, b.CenterCoords.Y)
+	b.Driver.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y+scrollBy)
+	b.Driver.MilliSleep(500)
+	postImg := b.Driver.CaptureImg()
 	// Revert the scroll after check
-	robotgo.Move(b.CenterCoords.X, b.CenterCoords.Y)
-	robotgo.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y-scrollBy)
-	robotgo.MilliSleep(500)
+	b.Driver.Move(b.CenterCoords.X, b.CenterCoords.Y)
+	b.Driver.DragSmooth(b.CenterCoords.X, b.CenterCoords.Y-scrollBy)
+	b.Driver.MilliSleep(500)
 
 	// res := gcv.FindAllImg(postImg, preImg)
 	// Use an image similarity comparison instead
@@ -187,27 +180,52 @@ func (b *BlueStacks) GetCoordsFromCV(cvResult gcv.Result, screenImg image.Image)
 	return b.GetCoords(cvResult.Middle.X, cvResult.Middle.Y, screenImg)
 }
 
+// Convert a pixel of a screenshot to pointer coordinates.
 func (b *BlueStacks) GetCoords(x, y int, screenImg image.Image) Coords {
-	landmark := map[string]float64{
-		"X": float64(x) / float64(screenImg.Bounds().Dx()),
-		"Y": float64(y) / float64(screenImg.Bounds().Dy()),
-	}
-	coords := Coords{
-		X: int(math.Round(landmark["X"] * float64(b.ScreenWidth))),
-		Y: int(math.Round(landmark["Y"] * float64(b.ScreenHeight))),
-	}
+	return b.coordSpace().ToPointer(x, y, screenImg)
+}
+
+// Convert pointer coordinates to a pixel of a screenshot.
+func (b *BlueStacks) GetImagePoint(coords Coords, screenImg image.Image) image.Point {
+	return b.coordSpace().ToImage(coords, screenImg)
+}
 
-	return coords
+// Convert a length in pixels of a full size screenshot to pointer points.
+func (b *BlueStacks) PixelsToPointer(pixels int, screenImg image.Image) int {
+	return b.coordSpace().PixelsToPointer(pixels, screenImg)
+}
+
+// Override the display scale measured from screenshots, eg. 2 for a Retina display. A scale of 0 measures it again.
+func (b *BlueStacks) SetDisplayScale(scale float64) {
+	space := b.coordSpace()
+	space.Scale = scale
+	b.SetCoordSpace(space)
 }
 
 // We have a process of resizing the search image to determine the result with the best confidence.
-func (b *BlueStacks) GetImageCoordsInImage(searchImg, sourceImg image.Image) (Coords, float32, error) {
+// The best match is returned along with an error when it is below minConfidence.
+func (b *BlueStacks) GetImageCoordsInImage(searchImg, sourceImg image.Image, minConfidence float32) (Coords, float32, error) {
+	point, confidence, err := b.findImageInImage(searchImg, sourceImg, minConfidence)
+	if err != nil && confidence == 0 {
+		return Coords{}, 0, err
+	}
+	return b.GetCoords(point.X, point.Y, sourceImg), confidence, err
+}
+
+// Find the middle of the search image inside of the source image, in pixels of the source image.
+// Every scale is searched until a match is found with at least templateConfidentMatch, or minConfidence if that is higher. The best match is returned along with an error when it is below minConfidence.
+func (b *BlueStacks) findImageInImage(searchImg, sourceImg image.Image, minConfidence float32) (image.Point, float32, error) {
 	searchMat, err := gocv.ImageToMatRGB(searchImg)
 	if err != nil {
-		return Coords{}, 0, err
+		return image.Point{}, 0, err
 	}
 	defer searchMat.Close()
 
+	confidentMatch := float32(templateConfidentMatch)
+	if minConfidence > confidentMatch {
+		confidentMatch = minConfidence
+	}
+
 	// Produce multiple sizes for the search image
 	var res CVResult
 	for i := 0; i < 10; i++ {
@@ -232,22 +250,30 @@ func (b *BlueStacks) GetImageCoordsInImage(searchImg, sourceImg image.Image) (Co
 			SourceImage: rImg,
 		}
 
-		if r.Confidence > res.Confidence && r.Confidence > 0.5 {
+		if res.SourceImage == nil || r.Confidence > res.Confidence {
 			res = r
 
-			if r.Confidence > 0.9 {
+			if r.Confidence >= confidentMatch {
 				break
 			}
 		}
 	}
 
-	if res.Confidence == 0 {
-		return Coords{}, 0, errors.New("Cannot find image inside of source image")
+	if res.SourceImage == nil {
+		return image.Point{}, 0, errors.New("Cannot find image inside of source image")
 	}
 
-	coords := b.GetCoords(res.Point.X+res.SearchImage.Bounds().Dx()/2, res.Point.Y+res.SearchImage.Bounds().Dy()/2, res.SourceImage)
-
-	return coords, res.Confidence, nil
+	// Scale the middle of the match on the resized source back up to the source image.
+	scaleX := float64(sourceImg.Bounds().Dx()) / float64(res.SourceImage.Bounds().Dx())
+	scaleY := float64(sourceImg.Bounds().Dy()) / float64(res.SourceImage.Bounds().Dy())
+	point := image.Point{
+		X: int(math.Round(float64(res.Point.X+res.SearchImage.Bounds().Dx()/2) * scaleX)),
+		Y: int(math.Round(float64(res.Point.Y+res.SearchImage.Bounds().Dy()/2) * scaleY)),
+	}
+	if res.Confidence < minConfidence {
+		return point, res.Confidence, fmt.Errorf("Confidence %.3f is below %.3f", res.Confidence, minConfidence)
+	}
+	return point, res.Confidence, nil
 }
 
 func (b *BlueStacks) GetImagePathCoordsInImage(imagePath string, sourceImg image.Image) (Coords, float32, error) {
@@ -256,162 +282,191 @@ func (b *BlueStacks) GetImagePathCoordsInImage(imagePath string, sourceImg image
 		return Coords{}, 0, fmt.Errorf("%v: %s", err, imagePath)
 	}
 
-	coords, confidence, err := b.GetImageCoordsInImage(searchImg, sourceImg)
+	coords, confidence, err := b.GetImageCoordsInImage(searchImg, sourceImg, assetDefaultMinConfidence)
+	decision := SessionDecision{
+		Kind:       DecisionTemplate,
+		Template:   imagePath,
+		Coords:     coords,
+		Confidence: confidence,
+	}
+	if err != nil {
+		decision.Error = err.Error()
+	}
+	b.recordDecision(sourceImg, decision)
 	if err != nil {
 		return coords, 0, fmt.Errorf("%v: %s", err, imagePath)
 	}
 	return coords, confidence, nil
 }
 
-func (b *BlueStacks) GetCoordsWithCache(getCoords func() (Coords, error), cacheKey string) (Coords, error) {
-	var coords Coords
-	var err error
-	if v, found := coordsCache[cacheKey]; found {
-		coords = v
-	} else {
-		coords, err = getCoords()
-		if err != nil {
-			return coords, err
-		}
-		coordsCache[cacheKey] = coords
+// Let a recording driver log what was decided from the screenshot, so a replay can compare against it.
+func (b *BlueStacks) recordDecision(screenImg image.Image, decision SessionDecision) {
+	if recorder, ok := b.Driver.(DecisionRecorder); ok {
+		recorder.RecordDecision(screenImg, decision)
 	}
-	return coords, nil
 }
 
-func (b *BlueStacks) DetectFaces(img image.Image, validity int) []image.Rectangle {
-	// prepare image matrix
-	screenMat, _ := gocv.ImageToMatRGB(img)
-	defer screenMat.Close()
+// Find every match of the search image inside of the source image above the confidence threshold.
+// Each of the resize passes used by GetImageCoordsInImage is searched, then overlapping matches are suppressed so that only the most confident of them is kept.
+// Matches are ranked by confidence, highest first.
+func (b *BlueStacks) FindAllImageCoordsInImage(searchImg, sourceImg image.Image, threshold float32) ([]ImageMatch, error) {
+	searchMat, err := gocv.ImageToMatRGB(searchImg)
+	if err != nil {
+		return nil, err
+	}
+	defer searchMat.Close()
 
-	// detect faces
-	rects := b.FaceClassifier.DetectMultiScale(screenMat)
-	var detectedFaces []image.Rectangle
-	for _, r := range rects {
-		if r.Dx() > validity {
-			detectedFaces = append(detectedFaces, r)
+	var matches []ImageMatch
+	for i := 0; i < 10; i++ {
+		resizeWidth := int(math.Round(float64(sourceImg.Bounds().Dx()) * (1.0 - float64(i)/10.0)))
+		rImg := imaging.Resize(sourceImg, resizeWidth, 0, imaging.Lanczos)
+		if rImg.Bounds().Dx() <= searchImg.Bounds().Dx() || rImg.Bounds().Dy() <= searchImg.Bounds().Dy() {
+			break // Break the loop if the source image resize becomes smaller than the search image.
+		}
+		srcMat, err := gocv.ImageToMatRGB(rImg)
+		if err != nil {
+			return nil, err
+		}
+		results := gcv.FindAllTemplate(srcMat, searchMat, float64(threshold), 20)
+		srcMat.Close()
+
+		scale := float64(sourceImg.Bounds().Dx()) / float64(rImg.Bounds().Dx())
+		for _, r := range results {
+			topLeft := b.GetCoords(r.Rects.TopLeft.X, r.Rects.TopLeft.Y, rImg)
+			bottomRight := b.GetCoords(r.Rects.BottomRight.X, r.Rects.BottomRight.Y, rImg)
+			matches = append(matches, ImageMatch{
+				Coords:     b.GetCoords(r.Middle.X, r.Middle.Y, rImg),
+				Rect:       image.Rect(topLeft.X, topLeft.Y, bottomRight.X, bottomRight.Y),
+				Confidence: r.MaxVal[0],
+				Scale:      scale,
+			})
 		}
 	}
 
-	return detectedFaces
+	matches = suppressOverlappingMatches(matches, 0.3)
+	if len(matches) == 0 {
+		return nil, errors.New("Cannot find image inside of source image")
+	}
+	return matches, nil
 }
 
-// Some Screen Movement Functions
-func (b *BlueStacks) MoveToSharedFolderFromHome() error {
-	// b.ScrollUp(50) // Scroll up to ensure that gallery button shows.
-	// No longer required at the Gallery control shows always in FaceApp 10.1+
-
-	var err error
-	var galleryControlCoords Coords
-	var confidence float32
-	if v, found := coordsCache["gallery"]; found {
-		galleryControlCoords = v
-	} else {
-		screenImg := robotgo.CaptureImg()
-		galleryControlCoords, confidence, err = b.GetImagePathCoordsInImage("./assets/faceapp/gallery.png", screenImg)
-		log.Printf("DEBUG: Found gallery control with gallery.png - coords: %v , confidence: %v\n", galleryControlCoords, confidence)
-		if err != nil || confidence < 0.9 {
-			galleryControlCoords, confidence, err = b.GetImagePathCoordsInImage("./assets/faceapp/gallery-2.png", screenImg)
-			log.Printf("DEBUG: Found gallery control with gallery-2.png - coords: %v , confidence: %v\n", galleryControlCoords, confidence)
-			if err != nil || confidence < 0.9 {
-				galleryControlCoords, _, err = b.GetImagePathCoordsInImage("./assets/faceapp/gallery-3.png", screenImg)
-				log.Printf("DEBUG: Found gallery control with gallery-3.png - coords: %v , confidence: %v\n", galleryControlCoords, confidence)
-				if err != nil {
-					return err
-				}
+// Non-maximum suppression -- rank the matches by confidence and drop any that overlap a more confident match by more than maxOverlap.
+func suppressOverlappingMatches(matches []ImageMatch, maxOverlap float64) []ImageMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Confidence > matches[j].Confidence
+	})
+	var kept []ImageMatch
+	for _, m := range matches {
+		overlaps := false
+		for _, k := range kept {
+			if rectOverlap(m.Rect, k.Rect) > maxOverlap {
+				overlaps = true
+				break
 			}
 		}
-		coordsCache["gallery"] = galleryControlCoords
-	}
-	// log.Printf("DEBUG: Scrolling to Gallery Coords %v\n", galleryControlCoords)
-	b.MoveClick(galleryControlCoords.X, galleryControlCoords.Y)
-	robotgo.MilliSleep(1000) // Wait for animation to finish
-
-	var folderFilterControlCoords Coords
-	if v, found := coordsCache["filterFolder"]; found {
-		folderFilterControlCoords = v
-	} else {
-		screenImg := robotgo.CaptureImg()
-		folderFilterControlCoords, _, err = b.GetImagePathCoordsInImage("./assets/faceapp/folder-filter.png", screenImg)
-		if err != nil {
-			return err
+		if !overlaps {
+			kept = append(kept, m)
 		}
-		coordsCache["filterFolder"] = folderFilterControlCoords
 	}
-	b.MoveClick(folderFilterControlCoords.X, folderFilterControlCoords.Y)
-	robotgo.MilliSleep(1000) // Wait for animation to finish
+	return kept
+}
 
-	//* Opting for a Hotkey approach to minimise room for error
-	robotgo.KeyTap("down")
-	// robotgo.KeyTap("down") // Leave one out ... as the new FaceApp orders SharedFolder before FaceApp folder.
-	robotgo.KeyTap("down")
-	robotgo.KeyTap("enter")
-	robotgo.KeyTap("tab")
-	robotgo.MilliSleep(1000) // Wait for the shared folder gallery to actually load
+func (b *BlueStacks) FindAllImagePathCoordsInImage(imagePath string, sourceImg image.Image, threshold float32) ([]ImageMatch, error) {
+	searchImg, _, err := robotgo.DecodeImg(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, imagePath)
+	}
 
-	return nil
+	matches, err := b.FindAllImageCoordsInImage(searchImg, sourceImg, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, imagePath)
+	}
+	return matches, nil
+}
+
+// Get coordinates with the cache, for points that are not found from a template at all.
+// These entries cannot be validated against the screen, so they are reused as-is for the current run only.
+func (b *BlueStacks) GetCoordsWithCache(getCoords func() (Coords, error), cacheKey string) (Coords, error) {
+	return b.GetTemplateCoordsWithCache(func() (Coords, string, error) {
+		coords, err := getCoords()
+		return coords, "", err
+	}, cacheKey, nil)
+}
+
+// Detect the faces in a screenshot, ignoring faces narrower than minWidth -- a fraction of the screenshot width.
+func (b *BlueStacks) DetectFaces(img image.Image, minWidth float64) []FaceDetection {
+	detections, err := b.FaceDetector.Detect(img)
+	if err != nil {
+		log.Printf("WARN: Cannot detect faces - %v\n", err.Error())
+	}
+	detectedFaces := filterFaces(detections, img.Bounds(), minWidth)
+	b.recordDecision(img, SessionDecision{
+		Kind:     DecisionFaces,
+		MinWidth: minWidth,
+		Faces:    detectedFaces,
+	})
+
+	return detectedFaces
+}
+
+// Some Screen Movement Functions
+// Open the SharedFolder in the FaceApp gallery from any recognised screen.
+func (b *BlueStacks) MoveToSharedFolderFromHome() error {
+	return b.FollowRoute(StateGallery, StateFolderPicker, StateGallery)
 }
 
 func (b *BlueStacks) OsBackClick() error {
 	// var err error
 	// var backControlCoords Coords
-	// if v, found := coordsCache["osback"]; found {
+	// if v, found := b.CachedCoords("osback"); found {
 	// 	backControlCoords = v
 	// } else {
-	// 	screenImg := robotgo.CaptureImg()
+	// 	screenImg := b.Driver.CaptureImg()
 	// 	backControlCoords, _, err = b.GetImagePathCoordsInImage("./assets/faceapp/os-back.png", screenImg)
 	// 	if err != nil {
 	// 		return err
 	// 	}
-	// 	coordsCache["osback"] = backControlCoords
+	// 	b.CoordsCache.Set("osback", CoordsCacheEntry{Coords: backControlCoords})
 	// }
 
 	// b.MoveClick(backControlCoords.X, backControlCoords.Y)
-	// robotgo.MilliSleep(1000)
+	// b.Driver.MilliSleep(1000)
 
-	robotgo.KeyTap("esc")
+	b.Driver.KeyTap("esc")
 
 	return nil
 }
 
-// Used within the Enhancements Loop
-func (b *BlueStacks) ExitScreen(shouldExitModal bool) error {
-	err := b.OsBackClick()
-	if err != nil {
-		return err
-	}
-	if shouldExitModal {
-		robotgo.MilliSleep(1000)
-		exitModalScreen := robotgo.CaptureImg()
-		exitCoords, err := b.GetCoordsWithCache(func() (Coords, error) {
-			coords, _, err := b.GetImagePathCoordsInImage("./assets/faceapp/exit.png", exitModalScreen)
-			return coords, err
-		}, "exit")
-		if err != nil {
-			return err
-		}
-		b.MoveClick(exitCoords.X, exitCoords.Y)
-		robotgo.MilliSleep(1000)
-	}
-
-	return nil
+// Used within the Enhancements Loop -- Exit the editor back to the gallery, confirming the exit modal if it is shown.
+func (b *BlueStacks) ExitScreen() error {
+	return b.NavigateTo(StateGallery)
 }
 
-func (b *BlueStacks) WaitForElement(assetTemplatePath string, waitFor int, maxCount int) bool {
-	count := 0
-	isAvailable := false
-	for {
-		count++
-		robotgo.MilliSleep(2000)
-		currentScreen := robotgo.CaptureImg()
-		_, _, err := b.GetImagePathCoordsInImage("./assets/faceapp/filepicker-indicator.png", currentScreen)
+// Close the Media Manager tab to return to the BlueStacks home screen.
+func (b *BlueStacks) CloseMediaManager() error {
+	currentScreen := b.Driver.CaptureImg()
+	mediaManagerTabCloseCoords, err := b.GetDerivedCoordsWithCache(func() (CoordsCacheEntry, error) {
+		mediaManagerTabCoords, mediaManagerTabImagePath, _, err := b.locate("media-manager-tab-control", currentScreen, 0)
 		if err != nil {
-			if count > maxCount {
-				break
-			}
-			continue
+			return CoordsCacheEntry{}, err
+		}
+		mediaManagerTabImg, _, err := imgo.DecodeFile(mediaManagerTabImagePath)
+		if err != nil {
+			return CoordsCacheEntry{}, fmt.Errorf("%v: %s", err, mediaManagerTabImagePath)
 		}
-		isAvailable = true
-		break
+		relativeWidth := b.PixelsToPointer(mediaManagerTabImg.Bounds().Dx(), currentScreen)
+		return CoordsCacheEntry{
+			Coords: Coords{
+				X: mediaManagerTabCoords.X + relativeWidth/2,
+				Y: mediaManagerTabCoords.Y,
+			},
+			Template: mediaManagerTabImagePath,
+			Anchor:   &mediaManagerTabCoords,
+		}, nil
+	}, "media-tab", currentScreen)
+	if err != nil {
+		return err
 	}
-	return isAvailable
+	b.MoveClick(mediaManagerTabCloseCoords.X, mediaManagerTabCloseCoords.Y)
+	return nil
 }
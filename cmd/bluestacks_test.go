@@ -125,6 +125,25 @@ func TestGetImagePathCoordsInImage(t *testing.T) {
 	}
 }
 
+func TestSuppressOverlappingMatches(t *testing.T) {
+	matches := []ImageMatch{
+		{Coords: Coords{X: 15, Y: 15}, Rect: image.Rect(10, 10, 20, 20), Confidence: 0.7},
+		{Coords: Coords{X: 16, Y: 15}, Rect: image.Rect(11, 10, 21, 20), Confidence: 0.9}, // Same tile found at another scale
+		{Coords: Coords{X: 55, Y: 15}, Rect: image.Rect(50, 10, 60, 20), Confidence: 0.8},
+		{Coords: Coords{X: 19, Y: 15}, Rect: image.Rect(18, 10, 20, 20), Confidence: 0.6}, // Small overlap only
+	}
+	kept := suppressOverlappingMatches(matches, 0.3)
+	if len(kept) != 3 {
+		t.Fatalf("Expected 3 matches after suppression, got %d - %v", len(kept), kept)
+	}
+	expectedConfidences := []float32{0.9, 0.8, 0.6}
+	for i, m := range kept {
+		if m.Confidence != expectedConfidences[i] {
+			t.Errorf("Match %d - expected confidence %v, got %v", i, expectedConfidences[i], m.Confidence)
+		}
+	}
+}
+
 func getCoordsInImage(x, y, screenWidth, screenHeight int, img image.Image) Coords {
 	landmark := map[string]float64{
 		"X": float64(x) / float64(screenWidth),
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CoordSpace maps pixels of captured screenshots to pointer coordinates.
+// Window and Capture are in pointer coordinates -- points on HiDPI displays -- and may be offset from the origin when BlueStacks is windowed or on a second monitor.
+// Capture is the region each screenshot covers, which is the whole screen unless the driver captures a region.
+// Scale is the number of capture pixels per pointer point, eg. 2 on a Retina display. When it is 0 it is measured from each screenshot.
+type CoordSpace struct {
+	Window  Bounds  `json:"window"`
+	Capture Bounds  `json:"capture"`
+	Scale   float64 `json:"scale,omitempty"`
+}
+
+// A coordinate space where BlueStacks fills the captured region.
+func NewCoordSpace(capture Bounds) CoordSpace {
+	return CoordSpace{
+		Window:  capture,
+		Capture: capture,
+	}
+}
+
+// Convert a pixel of a screenshot to pointer coordinates.
+// The screenshot may be a resized copy of a capture, so the pixel is converted relative to the size of img.
+func (s CoordSpace) ToPointer(x, y int, img image.Image) Coords {
+	landmarkX := float64(x) / float64(img.Bounds().Dx())
+	landmarkY := float64(y) / float64(img.Bounds().Dy())
+	return Coords{
+		X: s.Capture.Left + int(math.Round(landmarkX*float64(s.Capture.Width))),
+		Y: s.Capture.Top + int(math.Round(landmarkY*float64(s.Capture.Height))),
+	}
+}
+
+// Convert pointer coordinates to a pixel of a screenshot. This is the inverse of ToPointer.
+func (s CoordSpace) ToImage(coords Coords, img image.Image) image.Point {
+	landmarkX := float64(coords.X-s.Capture.Left) / float64(s.Capture.Width)
+	landmarkY := float64(coords.Y-s.Capture.Top) / float64(s.Capture.Height)
+	return image.Point{
+		X: int(math.Round(landmarkX * float64(img.Bounds().Dx()))),
+		Y: int(math.Round(landmarkY * float64(img.Bounds().Dy()))),
+	}
+}
+
+// Convert a position relative to the window, where 0 is the top/left edge and 1 the bottom/right edge, to pointer coordinates.
+func (s CoordSpace) WindowToPointer(landmarkX, landmarkY float64) Coords {
+	return Coords{
+		X: s.Window.Left + int(math.Round(landmarkX*float64(s.Window.Width))),
+		Y: s.Window.Top + int(math.Round(landmarkY*float64(s.Window.Height))),
+	}
+}
+
+// Convert pointer coordinates to a position relative to the window. This is the inverse of WindowToPointer.
+func (s CoordSpace) PointerToWindow(coords Coords) (float64, float64) {
+	return float64(coords.X-s.Window.Left) / float64(s.Window.Width),
+		float64(coords.Y-s.Window.Top) / float64(s.Window.Height)
+}
+
+func (s CoordSpace) Center() Coords {
+	return s.WindowToPointer(0.5, 0.5)
+}
+
+// The number of capture pixels per pointer point for a full size screenshot.
+func (s CoordSpace) PixelScale(img image.Image) float64 {
+	if s.Scale > 0 {
+		return s.Scale
+	}
+	return float64(img.Bounds().Dx()) / float64(s.Capture.Width)
+}
+
+// Convert a length in pixels of a full size screenshot, such as the size of a template, to pointer points.
+func (s CoordSpace) PixelsToPointer(pixels int, img image.Image) int {
+	return int(math.Round(float64(pixels) / s.PixelScale(img)))
+}
+
+// Parse window bounds given as "left,top,width,height" in pointer coordinates.
+func parseBounds(value string) (Bounds, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return Bounds{}, fmt.Errorf("Bounds must be left,top,width,height - got %q", value)
+	}
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return Bounds{}, fmt.Errorf("Bounds must be left,top,width,height - got %q", value)
+		}
+		values[i] = v
+	}
+	if values[2] <= 0 || values[3] <= 0 {
+		return Bounds{}, fmt.Errorf("Bounds must have a positive width and height - got %q", value)
+	}
+	return Bounds{
+		Left:   values[0],
+		Top:    values[1],
+		Width:  values[2],
+		Height: values[3],
+	}, nil
+}
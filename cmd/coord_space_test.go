@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCoordSpaceWindowedHiDPI(t *testing.T) {
+	// A 1280x800 point window on a second monitor, captured on its own at a display scale of 2.
+	window := Bounds{Left: 1920, Top: 100, Width: 1280, Height: 800}
+	space := NewCoordSpace(window)
+	screenImg := image.NewRGBA(image.Rect(0, 0, 2560, 1600))
+
+	if coords := space.ToPointer(1280, 800, screenImg); coords != (Coords{X: 2560, Y: 500}) {
+		t.Errorf("Expected the middle of the capture at 2560,500 - got %v", coords)
+	}
+	if point := space.ToImage(Coords{X: 2560, Y: 500}, screenImg); point != image.Pt(1280, 800) {
+		t.Errorf("Expected ToImage to invert ToPointer - got %v", point)
+	}
+	if center := space.Center(); center != (Coords{X: 2560, Y: 500}) {
+		t.Errorf("Expected the window center at 2560,500 - got %v", center)
+	}
+	if length := space.PixelsToPointer(100, screenImg); length != 50 {
+		t.Errorf("Expected 100 capture pixels to be 50 points - got %d", length)
+	}
+	// Matching runs on resized screenshots, which convert relative to their own size.
+	resizedImg := image.NewRGBA(image.Rect(0, 0, 1280, 800))
+	if coords := space.ToPointer(640, 400, resizedImg); coords != (Coords{X: 2560, Y: 500}) {
+		t.Errorf("Expected a resized screenshot to convert to the same point - got %v", coords)
+	}
+
+	space.Scale = 4
+	if length := space.PixelsToPointer(100, screenImg); length != 25 {
+		t.Errorf("Expected an explicit scale to override the measured one - got %d", length)
+	}
+}
+
+func TestParseBounds(t *testing.T) {
+	bounds, err := parseBounds("1920, 100, 1280, 800")
+	if err != nil || bounds != (Bounds{Left: 1920, Top: 100, Width: 1280, Height: 800}) {
+		t.Errorf("Unexpected bounds %v - %v", bounds, err)
+	}
+	for _, value := range []string{"1,2,3", "a,b,c,d", "0,0,0,800"} {
+		if _, err := parseBounds(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-vgo/robotgo"
+)
+
+const (
+	// A cached point is only reused if its template is found again around it with at least this confidence.
+	coordsCacheMinConfidence = 0.8
+	// The region re-checked around a cached point, as a multiple of the template size.
+	coordsCacheRegionScale = 4
+	// Changes are written to disk at most this often. Whatever is left is written when the run closes the cache.
+	coordsCacheSaveInterval = 30 * time.Second
+)
+
+type CoordsCacheEntry struct {
+	Coords   Coords `json:"coords"`
+	Template string `json:"template,omitempty"`
+	// Where the template was found, when Coords is a point derived from it -- such as the close button at the end of a tab -- rather than the template itself.
+	Anchor *Coords `json:"anchor,omitempty"`
+}
+
+// CoordsCache holds the screen coordinates of UI elements between runs.
+// Entries are grouped by a profile key made of the screen size and the asset pack version, so that a new resolution or new templates start with an empty cache.
+// Entries without a template cannot be validated against the screen, so they are kept for the current run only -- the window may have moved by the next one.
+type CoordsCache struct {
+	Path       string
+	ProfileKey string
+
+	mu       sync.Mutex
+	profiles map[string]map[string]CoordsCacheEntry
+	run      map[string]CoordsCacheEntry
+	dirty    bool
+	savedAt  time.Time
+}
+
+// Creates a cache for the screen size and asset pack, loading any entries previously saved to cachePath.
+// An empty cachePath keeps the cache in memory only.
+func NewCoordsCache(cachePath string, screenWidth, screenHeight int, assetsDir string) (*CoordsCache, error) {
+	assetsVersion, err := getAssetsVersion(assetsDir)
+	if err != nil {
+		return nil, err
+	}
+	c := &CoordsCache{
+		Path:       cachePath,
+		ProfileKey: fmt.Sprintf("%dx%d-%s", screenWidth, screenHeight, assetsVersion),
+		profiles:   map[string]map[string]CoordsCacheEntry{},
+		run:        map[string]CoordsCacheEntry{},
+	}
+	if cachePath != "" {
+		file, err := ioutil.ReadFile(cachePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(file, &c.profiles)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %s", err, cachePath)
+			}
+		}
+	}
+	if c.profiles[c.ProfileKey] == nil {
+		c.profiles[c.ProfileKey] = map[string]CoordsCacheEntry{}
+	}
+	// Caches saved before entries were kept for the run only may still hold entries without a template
+	for cacheKey, entry := range c.profiles[c.ProfileKey] {
+		if entry.Template == "" {
+			delete(c.profiles[c.ProfileKey], cacheKey)
+		}
+	}
+	return c, nil
+}
+
+// The asset pack version is a hash of the template and manifest file names and contents.
+func getAssetsVersion(assetsDir string) (string, error) {
+	assetPaths, err := filepath.Glob(path.Join(assetsDir, "/*.png"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path.Join(assetsDir, assetManifestFile)); err == nil {
+		assetPaths = append(assetPaths, path.Join(assetsDir, assetManifestFile))
+	}
+	sort.Strings(assetPaths)
+	hash := sha1.New()
+	for _, assetPath := range assetPaths {
+		file, err := ioutil.ReadFile(assetPath)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(filepath.Base(assetPath)))
+		hash.Write(file)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[0:8], nil
+}
+
+func (c *CoordsCache) Get(cacheKey string) (CoordsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, found := c.run[cacheKey]; found {
+		return entry, found
+	}
+	entry, found := c.profiles[c.ProfileKey][cacheKey]
+	return entry, found
+}
+
+// Cache an entry. Entries without a template are kept for the current run only.
+func (c *CoordsCache) Set(cacheKey string, entry CoordsCacheEntry) {
+	c.mu.Lock()
+	if entry.Template == "" {
+		c.run[cacheKey] = entry
+		c.mu.Unlock()
+		return
+	}
+	c.profiles[c.ProfileKey][cacheKey] = entry
+	c.dirty = true
+	c.mu.Unlock()
+	c.saveDebounced()
+}
+
+func (c *CoordsCache) Evict(cacheKey string) {
+	c.mu.Lock()
+	delete(c.run, cacheKey)
+	if _, found := c.profiles[c.ProfileKey][cacheKey]; found {
+		delete(c.profiles[c.ProfileKey], cacheKey)
+		c.dirty = true
+	}
+	c.mu.Unlock()
+	c.saveDebounced()
+}
+
+// Save changes unless the cache was saved within the last coordsCacheSaveInterval, as markers are cached on most screenshots of a run.
+func (c *CoordsCache) saveDebounced() {
+	c.mu.Lock()
+	recent := time.Since(c.savedAt) < coordsCacheSaveInterval
+	c.mu.Unlock()
+	if !recent {
+		c.Save()
+	}
+}
+
+// Write any changes to disk.
+func (c *CoordsCache) Save() {
+	c.mu.Lock()
+	if c.Path == "" || !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	cacheJson, err := json.MarshalIndent(c.profiles, "", "  ")
+	c.dirty = false
+	c.savedAt = time.Now()
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("WARN: Cannot marshal coordinates cache - %v\n", err.Error())
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(c.Path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(c.Path, cacheJson, 0644)
+	}
+	if err != nil {
+		log.Printf("WARN: Cannot save coordinates cache to %v - %v\n", c.Path, err.Error())
+	}
+}
+
+func (b *BlueStacks) LoadCoordsCache(cachePath string) error {
+	if b.Assets == nil {
+		err := b.LoadAssets(faceappAssetsDir)
+		if err != nil {
+			return err
+		}
+	}
+	cache, err := NewCoordsCache(cachePath, b.ScreenWidth, b.ScreenHeight, b.Assets.Dir)
+	if err != nil {
+		return err
+	}
+	b.CoordsCache = cache
+	return nil
+}
+
+// BlueStacks values built without LoadCoordsCache fall back to an in-memory cache for the process.
+func (b *BlueStacks) coordsCache() *CoordsCache {
+	if b.CoordsCache == nil {
+		b.CoordsCache = &CoordsCache{
+			ProfileKey: fmt.Sprintf("%dx%d", b.ScreenWidth, b.ScreenHeight),
+			profiles:   map[string]map[string]CoordsCacheEntry{},
+			run:        map[string]CoordsCacheEntry{},
+		}
+		b.CoordsCache.profiles[b.CoordsCache.ProfileKey] = map[string]CoordsCacheEntry{}
+	}
+	return b.CoordsCache
+}
+
+// Returns the cached coordinates without validating them against the screen.
+func (b *BlueStacks) CachedCoords(cacheKey string) (Coords, bool) {
+	entry, found := b.coordsCache().Get(cacheKey)
+	return entry.Coords, found
+}
+
+// Re-check that the template is still shown in a small region of the screen around the cached point -- or around its anchor, for a derived point.
+func (b *BlueStacks) validateCachedCoords(entry CoordsCacheEntry, screenImg image.Image) error {
+	searchImg, _, err := robotgo.DecodeImg(entry.Template)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, entry.Template)
+	}
+	anchor := entry.Coords
+	if entry.Anchor != nil {
+		anchor = *entry.Anchor
+	}
+	point := b.GetImagePoint(anchor, screenImg)
+	x, y := point.X, point.Y
+	halfWidth := searchImg.Bounds().Dx() * coordsCacheRegionScale / 2
+	halfHeight := searchImg.Bounds().Dy() * coordsCacheRegionScale / 2
+	region := image.Rect(x-halfWidth, y-halfHeight, x+halfWidth, y+halfHeight).Intersect(screenImg.Bounds())
+	if region.Empty() {
+		return errors.New("Cached coordinates are outside of the screen")
+	}
+	regionImg := imaging.Crop(screenImg, region)
+	_, _, err = b.GetImageCoordsInImage(searchImg, regionImg, coordsCacheMinConfidence)
+	return err
+}
+
+// Get the coordinates of a template with the cache. The lookup returns the coordinates along with the template path that was found at them.
+// A cached entry with a template is validated against screenImg before it is reused, and is evicted and looked up again if the template is no longer there.
+func (b *BlueStacks) GetTemplateCoordsWithCache(lookup func() (Coords, string, error), cacheKey string, screenImg image.Image) (Coords, error) {
+	return b.getEntryWithCache(func() (CoordsCacheEntry, error) {
+		coords, template, err := lookup()
+		return CoordsCacheEntry{Coords: coords, Template: template}, err
+	}, cacheKey, screenImg)
+}
+
+// Get the coordinates of a point derived from a template with the cache. The derive function returns the point along with the template it was derived from and where that template was found, which the entry is validated against.
+func (b *BlueStacks) GetDerivedCoordsWithCache(derive func() (CoordsCacheEntry, error), cacheKey string, screenImg image.Image) (Coords, error) {
+	return b.getEntryWithCache(derive, cacheKey, screenImg)
+}
+
+func (b *BlueStacks) getEntryWithCache(lookup func() (CoordsCacheEntry, error), cacheKey string, screenImg image.Image) (Coords, error) {
+	cache := b.coordsCache()
+	if entry, found := cache.Get(cacheKey); found {
+		if entry.Template == "" || screenImg == nil {
+			return entry.Coords, nil
+		}
+		err := b.validateCachedCoords(entry, screenImg)
+		if err == nil {
+			return entry.Coords, nil
+		}
+		log.Printf("WARN: Coordinates cache miss for %v - %v no longer at %v - %v\n", cacheKey, entry.Template, entry.Coords, err.Error())
+		cache.Evict(cacheKey)
+	} else {
+		log.Printf("Coordinates cache miss for %v\n", cacheKey)
+	}
+	entry, err := lookup()
+	if err != nil {
+		return entry.Coords, err
+	}
+	cache.Set(cacheKey, entry)
+	return entry.Coords, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestCoordsCachePersistsPerProfile(t *testing.T) {
+	assetsDir := t.TempDir()
+	cachePath := path.Join(t.TempDir(), "coords-cache.json")
+	_ = ioutil.WriteFile(path.Join(assetsDir, "apply.png"), []byte("v1"), 0644)
+
+	cache, err := NewCoordsCache(cachePath, 1920, 1080, assetsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("editor-apply", CoordsCacheEntry{Coords: Coords{X: 10, Y: 20}, Template: "./assets/faceapp/apply.png"})
+	cache.Set("media-tab", CoordsCacheEntry{Coords: Coords{X: 30, Y: 40}})
+	cache.Save()
+
+	reloaded, err := NewCoordsCache(cachePath, 1920, 1080, assetsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, found := reloaded.Get("editor-apply"); !found || entry.Coords != (Coords{X: 10, Y: 20}) {
+		t.Errorf("Expected the entry to be reloaded from disk, got %v %v", entry, found)
+	}
+	if _, found := reloaded.Get("media-tab"); found {
+		t.Error("Expected an entry without a template to be kept for the run only")
+	}
+
+	otherScreen, _ := NewCoordsCache(cachePath, 2560, 1440, assetsDir)
+	if _, found := otherScreen.Get("editor-apply"); found {
+		t.Error("Expected a different screen size to start with an empty cache")
+	}
+
+	_ = ioutil.WriteFile(path.Join(assetsDir, "apply.png"), []byte("v2"), 0644)
+	newAssets, _ := NewCoordsCache(cachePath, 1920, 1080, assetsDir)
+	if _, found := newAssets.Get("editor-apply"); found {
+		t.Error("Expected a changed asset pack to start with an empty cache")
+	}
+
+	_ = ioutil.WriteFile(path.Join(assetsDir, "apply.png"), []byte("v1"), 0644)
+	reloaded.Evict("editor-apply")
+	reloaded.Save()
+	evicted, _ := NewCoordsCache(cachePath, 1920, 1080, assetsDir)
+	if _, found := evicted.Get("editor-apply"); found {
+		t.Error("Expected the evicted entry to be removed from disk")
+	}
+}
+
+func TestCoordsCacheDebouncesSaves(t *testing.T) {
+	cachePath := path.Join(t.TempDir(), "coords-cache.json")
+	cache, err := NewCoordsCache(cachePath, 1920, 1080, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("state-apply", CoordsCacheEntry{Coords: Coords{X: 1, Y: 2}, Template: "./assets/faceapp/apply.png"})
+	cache.Set("state-save", CoordsCacheEntry{Coords: Coords{X: 3, Y: 4}, Template: "./assets/faceapp/save.png"})
+
+	saved, _ := NewCoordsCache(cachePath, 1920, 1080, t.TempDir())
+	if _, found := saved.Get("state-apply"); !found {
+		t.Error("Expected the first change to be saved straight away")
+	}
+	if _, found := saved.Get("state-save"); found {
+		t.Error("Expected a change soon after to wait for the next save")
+	}
+
+	cache.Save()
+	saved, _ = NewCoordsCache(cachePath, 1920, 1080, t.TempDir())
+	if _, found := saved.Get("state-save"); !found {
+		t.Error("Expected the change to be saved when the cache is saved")
+	}
+}
+
+func TestGetCoordsWithCacheOnlyLooksUpOnce(t *testing.T) {
+	bluestacks := &BlueStacks{ScreenWidth: 1920, ScreenHeight: 1080}
+	lookups := 0
+	getCoords := func() (Coords, error) {
+		lookups++
+		return Coords{X: 1, Y: 2}, nil
+	}
+	for i := 0; i < 3; i++ {
+		coords, err := bluestacks.GetCoordsWithCache(getCoords, "media-tab")
+		if err != nil || coords != (Coords{X: 1, Y: 2}) {
+			t.Fatalf("Unexpected coords %v - %v", coords, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected a single lookup, got %d", lookups)
+	}
+	if coords, found := bluestacks.CachedCoords("media-tab"); !found || coords != (Coords{X: 1, Y: 2}) {
+		t.Errorf("Expected cached coords, got %v %v", coords, found)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	cli "github.com/spf13/cobra"
+)
+
+const (
+	DryRunSkipEnhanced   = "already-enhanced"
+	DryRunSkipNotPlanned = "not-in-plan"
+)
+
+// DryRun is every decision an enhancement run would make, along with the actions it would take and the templates it would need to locate.
+type DryRun struct {
+	Command          string        `json:"command"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	Seed             int64         `json:"seed"`
+	Images           []DryRunImage `json:"images"`
+	Templates        []string      `json:"templates"`
+	MissingTemplates []string      `json:"missingTemplates,omitempty"`
+}
+
+// DryRunImage is what a run would do with one image. Images that would be skipped have the reason code in Skip, and no actions.
+type DryRunImage struct {
+	Id           string               `json:"id"`
+	Path         string               `json:"path,omitempty"`
+	Seed         int64                `json:"seed,omitempty"`
+	Skip         string               `json:"skip,omitempty"`
+	Detail       string               `json:"detail,omitempty"`
+	Enhancements []PlannedEnhancement `json:"enhancements,omitempty"`
+	Actions      []string             `json:"actions,omitempty"`
+	Templates    []string             `json:"templates,omitempty"`
+}
+
+// A step of enhancing an image, and the templates it locates on screen.
+type dryRunStep struct {
+	Action    string
+	Templates []string
+}
+
+// Make every decision of an enhancement run -- facedata lookup, the safety policy, the already enhanced check, eligibility and type selection -- without touching BlueStacks.
+// The dry run is logged, and written to the output directory.
+func DryRunEnhancements(cmd *cli.Command, importing bool) {
+	debugMode, _ = cmd.Flags().GetBool("debug")
+	outputParentDir, _ := cmd.Flags().GetString("output")
+	resumeDir, _ := cmd.Flags().GetString("resume")
+	sourceDir, _ := cmd.Flags().GetString("source")
+	facedataDir, _ := cmd.Flags().GetString("facedata")
+
+	manifest, err := LoadAssetManifest(faceappAssetsDir)
+	if err != nil {
+		logger.Step("dry-run").Fatalf("Cannot load asset manifest - %v", err.Error())
+	}
+	useEnhancementCatalogueFlag(cmd, manifest)
+	useSafetyPolicyFlag(cmd)
+
+	facedataPaths, err := filepath.Glob(path.Join(facedataDir, "/*.json"))
+	if err != nil {
+		logger.Step("dry-run").Fatalf("%v", err.Error())
+	}
+	var alreadyEnhanced []IndexedImage
+	if resumeDir != "" {
+		alreadyEnhanced, err = LoadRunJournal(resumeDir)
+		if err != nil {
+			logger.Step("dry-run").Fatalf("Cannot resume %v - %v", resumeDir, err.Error())
+		}
+	}
+	indexPaths, err := runIndexPaths(outputParentDir, resumeDir)
+	if err != nil {
+		logger.Step("dry-run").Fatalf("%v", err.Error())
+	}
+	quotas, err := LoadQuotaTracker(indexPaths)
+	if err != nil {
+		logger.Step("dry-run").Fatalf("Cannot count enhancements of previous runs - %v", err.Error())
+	}
+
+	// The faces a run would source -- enhance-v2 imports the source images, while enhance finds faces in the gallery, so every image with facedata is tried
+	var plan *EnhancementPlan
+	var faces []SourcedFace
+	if importing {
+		planPath, _ := cmd.Flags().GetString("plan")
+		limit, _ := cmd.Flags().GetInt("limit")
+		offset, _ := cmd.Flags().GetInt("offset")
+		if planPath != "" {
+			plan, err = LoadEnhancementPlan(planPath)
+			if err != nil {
+				logger.Step("dry-run").Fatalf("Cannot load plan - %v", err.Error())
+			}
+		}
+		imagePaths, err := enhanceV2ImagePaths(sourceDir, plan, offset, limit)
+		if err != nil {
+			logger.Step("dry-run").Fatalf("%v", err.Error())
+		}
+		for i, imagePath := range imagePaths {
+			faces = append(faces, SourcedFace{Index: i, ImageId: getFileName(imagePath), Path: imagePath})
+		}
+	} else {
+		for i, facedataPath := range facedataPaths {
+			faces = append(faces, SourcedFace{Index: i, ImageId: getFileName(facedataPath)})
+		}
+	}
+
+	dryRun := NewDryRun(cmd.CommandPath(), currentTs, faces, alreadyEnhanced, plan, facedataPaths, quotas, importing, outputParentDir, manifest)
+	dryRun.CheckTemplates(manifest)
+
+	for _, image := range dryRun.Images {
+		if image.Skip != "" {
+			logger.Image(image.Id).Step("dry-run").Infof("Skip - %v", image.Skip)
+			continue
+		}
+		var chosen []string
+		for _, planned := range image.Enhancements {
+			chosen = append(chosen, planned.Name+" : "+planned.Type)
+		}
+		if len(chosen) == 0 {
+			chosen = append(chosen, "no enhancements")
+		}
+		logger.Image(image.Id).Step("dry-run").Infof("%v - %d actions", strings.Join(chosen, ", "), len(image.Actions))
+	}
+	for _, unmet := range quotas.Unmet() {
+		logger.Step("dry-run").Warnf("%v", unmet)
+	}
+	for _, missing := range dryRun.MissingTemplates {
+		logger.Step("dry-run").Warnf("Template %v is not in the asset manifest", missing)
+	}
+
+	dryRunPath := path.Join(outputParentDir, fmt.Sprintf("dry-run-%d.json", currentTs))
+	err = dryRun.Save(dryRunPath)
+	if err != nil {
+		logger.Step("dry-run").Fatalf("%v", err.Error())
+	}
+	logger.Step("dry-run").Infof("Dry run of %d images needing %d templates written to %v", len(dryRun.Images), len(dryRun.Templates), dryRunPath)
+	if plan == nil {
+		logger.Step("dry-run").Infof("Enhancements were chosen with seed %d. Write a plan with enhance plan --seed %d to carry out these choices", dryRun.Seed, dryRun.Seed)
+	}
+}
+
+// Decide what a run would do with each face, in the order the run would source them.
+// Without a plan, faces are planned as the engine plans them -- quotas expect every face that would be enhanced, and count each as it is planned.
+func NewDryRun(command string, seed int64, faces []SourcedFace, alreadyEnhanced []IndexedImage, plan *EnhancementPlan, facedataPaths []string, quotas *QuotaTracker, importing bool, outputDir string, manifest *AssetManifest) *DryRun {
+	dryRun := &DryRun{
+		Command:   command,
+		CreatedAt: time.Now(),
+		Seed:      seed,
+	}
+	enhanced := map[string]bool{}
+	for _, indexedImage := range alreadyEnhanced {
+		enhanced[indexedImage.Id] = true
+	}
+	if plan != nil {
+		dryRun.Seed = plan.Seed
+	} else {
+		for _, face := range faces {
+			facedata, err := loadFaceData(facedataPaths, face.ImageId)
+			if !enhanced[face.ImageId] && err == nil && safetyPolicy.Check(face.ImageId, facedata) == "" {
+				quotas.Expect(face.ImageId, facedata.FaceDetails[0])
+			}
+		}
+	}
+
+	templates := map[string]bool{}
+	for _, face := range faces {
+		image := DryRunImage{Id: face.ImageId, Path: face.Path}
+		var planned PlannedImage
+		switch {
+		case enhanced[face.ImageId]:
+			image.Skip = DryRunSkipEnhanced
+		case plan != nil:
+			// The safety policy is applied whatever the plan says, as it is when the plan is enhanced
+			facedata, err := loadFaceData(facedataPaths, face.ImageId)
+			if err != nil {
+				image.Skip = PolicySkipNoFacedata
+				break
+			}
+			if image.Skip = safetyPolicy.Check(face.ImageId, facedata); image.Skip != "" {
+				break
+			}
+			var found bool
+			if planned, found = plan.Image(face.ImageId); !found {
+				image.Skip = DryRunSkipNotPlanned
+			}
+		default:
+			facedata, err := loadFaceData(facedataPaths, face.ImageId)
+			if err != nil {
+				image.Skip = PolicySkipNoFacedata
+				break
+			}
+			planned = planImage(imageSeed(seed, face.ImageId), face.ImageId, facedata, quotas)
+			if planned.Skip == "" {
+				quotas.Record(planned.indexed())
+			}
+		}
+		if image.Skip == "" {
+			image.Seed = planned.Seed
+			image.Skip = planned.Skip
+		}
+		if image.Skip == "" {
+			image.Enhancements = planned.Enhancements
+			selected, err := planned.Selected()
+			if err != nil {
+				image.Skip = PlanSkipInvalid
+				image.Detail = err.Error()
+			} else {
+				for _, step := range dryRunSteps(manifest, face, selected, importing, outputDir) {
+					image.Actions = append(image.Actions, step.Action)
+					for _, template := range step.Templates {
+						if !templates[template] {
+							templates[template] = true
+							dryRun.Templates = append(dryRun.Templates, template)
+						}
+						image.Templates = appendUnique(image.Templates, template)
+					}
+				}
+			}
+		}
+		dryRun.Images = append(dryRun.Images, image)
+	}
+	sort.Strings(dryRun.Templates)
+	return dryRun
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// The steps the engine takes to enhance a face with its selected enhancements.
+// The templates of the steps that navigate are those of the route they take through the navigation graph.
+func dryRunSteps(manifest *AssetManifest, face SourcedFace, selected []SelectedEnhancement, importing bool, outputDir string) []dryRunStep {
+	var steps []dryRunStep
+	home := StateHome // The home screen of the face source, that every face ends back at
+	if importing {
+		home = StateBlueStacksHome
+		steps = append(steps,
+			dryRunStep{"Open Media Manager", []string{"media-manager-app-control"}},
+			dryRunStep{fmt.Sprintf("Import %v through the file picker", face.Path), []string{"import-control", "filepicker-indicator"}},
+			dryRunStep{"Close Media Manager", []string{"media-manager-tab-control"}},
+			dryRunStep{"Open FaceApp", []string{"faceapp-app-control"}},
+			dryRunNavigationStep(manifest, "Open the SharedFolder and select the first image", StateHome, StateGallery, StateFolderPicker, StateGallery),
+		)
+	} else {
+		steps = append(steps,
+			dryRunNavigationStep(manifest, "Open the SharedFolder", StateHome, StateGallery, StateFolderPicker, StateGallery),
+			dryRunStep{"Find the face in the SharedFolder, identify it with Rekognition and open it", nil},
+		)
+	}
+	steps = append(steps,
+		dryRunStep{"Wait for the editor to show enhancements", []string{enhancementElement(enhancements[0].Name)}},
+		dryRunStep{"Select the female interface", []string{"editor-header", "gender-switch-icon", "gender-switch-female-option"}},
+	)
+	for _, s := range selected {
+		steps = append(steps, dryRunStep{fmt.Sprintf("Select enhancement %v", s.Enhancement.Name), []string{enhancementElement(s.Enhancement.Name)}})
+		if s.Type.ScrollRequirement > 0 {
+			for _, reference := range s.Enhancement.Types {
+				if reference.ScrollRequirement == 0 {
+					scrollIterations := int(math.Round(float64(s.Type.ScrollRequirement) / 200.0))
+					steps = append(steps, dryRunStep{fmt.Sprintf("Scroll %d times from type %v", scrollIterations, reference.Name), []string{enhancementTypeElement(s.Enhancement.Name, reference.Name)}})
+					break
+				}
+			}
+		}
+		steps = append(steps,
+			dryRunStep{fmt.Sprintf("Select type %v", s.Type.Name), []string{enhancementTypeElement(s.Enhancement.Name, s.Type.Name)}},
+			dryRunStep{"Apply", []string{"apply"}},
+		)
+	}
+	if len(selected) > 0 {
+		steps = append(steps,
+			dryRunStep{"Save", []string{"save"}},
+			dryRunStep{fmt.Sprintf("Crop the enhanced face to %v", path.Join(outputDir, "<run>", face.ImageId+".jpeg")), nil},
+		)
+	}
+	// Leaving an edited image shows the exit modal on the way back to the gallery
+	returnHome := dryRunNavigationStep(manifest, "Return home", StateEditor, home)
+	for _, template := range dryRunNavigationStep(manifest, "Confirm the exit modal", StateExitModal, StateGallery).Templates {
+		returnHome.Templates = appendUnique(returnHome.Templates, template)
+	}
+	return append(steps, returnHome)
+}
+
+// A step that navigates from a screen through each of the waypoints. A route that cannot be planned is noted in the action.
+func dryRunNavigationStep(manifest *AssetManifest, action string, from ScreenState, waypoints ...ScreenState) dryRunStep {
+	templates, err := routeTemplates(manifest, from, waypoints...)
+	if err != nil {
+		action = fmt.Sprintf("%v - %v", action, err.Error())
+	}
+	return dryRunStep{action, templates}
+}
+
+// Record the templates the run needs that the asset manifest does not have.
+func (d *DryRun) CheckTemplates(manifest *AssetManifest) {
+	d.MissingTemplates = nil
+	for _, template := range d.Templates {
+		if _, err := manifest.Element(template); err != nil {
+			d.MissingTemplates = append(d.MissingTemplates, template)
+		}
+	}
+}
+
+func (d *DryRun) Save(dryRunPath string) error {
+	dryRunJson, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dryRunPath), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dryRunPath, dryRunJson, 0644)
+}
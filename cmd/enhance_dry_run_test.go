@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestNewDryRun(t *testing.T) {
+	dir := t.TempDir()
+	facedata := map[string]string{
+		"1": `{"FaceDetails": [{"AgeRange": {"Low": 25, "High": 33}, "Gender": {"Value": "Male"}, "Beard": {"Value": true}}]}`,
+		"2": `{"FaceDetails": [{"AgeRange": {"Low": 9, "High": 13}, "Gender": {"Value": "Male"}, "Beard": {"Value": true}}]}`,
+		"4": `{"FaceDetails": [{"AgeRange": {"Low": 25, "High": 33}, "Gender": {"Value": "Male"}, "Beard": {"Value": true}}]}`,
+	}
+	var facedataPaths []string
+	for id, contents := range facedata {
+		facedataPath := path.Join(dir, id+".json")
+		if err := ioutil.WriteFile(facedataPath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		facedataPaths = append(facedataPaths, facedataPath)
+	}
+	faces := []SourcedFace{
+		{Index: 0, ImageId: "1", Path: "./output/step2/1.jpeg"},
+		{Index: 1, ImageId: "2", Path: "./output/step2/2.jpeg"},
+		{Index: 2, ImageId: "3", Path: "./output/step2/3.jpeg"},
+		{Index: 3, ImageId: "4", Path: "./output/step2/4.jpeg"},
+	}
+	manifest := loadTestAssetManifest(t)
+	dryRun := NewDryRun("enhance-v2", 42, faces, []IndexedImage{{Id: "4"}}, nil, facedataPaths, nil, true, "./output/step2.1", manifest)
+
+	expectedSkips := []string{"", PolicySkipUnderage, PolicySkipNoFacedata, DryRunSkipEnhanced}
+	for i, image := range dryRun.Images {
+		if image.Skip != expectedSkips[i] {
+			t.Errorf("Image %v - expected skip %q, got %q", image.Id, expectedSkips[i], image.Skip)
+		}
+	}
+	enhanced := dryRun.Images[0]
+	if len(enhanced.Enhancements) == 0 || enhanced.Enhancements[0].Name != "Beards" {
+		t.Fatalf("Expected a beard for a bearded face, got %+v", enhanced.Enhancements)
+	}
+	// Along with the templates of each step, the run needs those of the routes it navigates -- to the SharedFolder, and back home through the exit modal
+	for _, template := range []string{"import-control", enhancementElement("Beards"), "apply", "save", "gallery", "folder-filter", "sharedfolder", "exit", "faceapp-app-control"} {
+		if !containsString(enhanced.Templates, template) || !containsString(dryRun.Templates, template) {
+			t.Errorf("Expected template %v to be needed, got %v", template, enhanced.Templates)
+		}
+	}
+	if len(dryRun.Images[1].Actions) != 0 {
+		t.Errorf("Expected no actions for a skipped image, got %v", dryRun.Images[1].Actions)
+	}
+
+	again := NewDryRun("enhance-v2", 42, faces, []IndexedImage{{Id: "4"}}, nil, facedataPaths, nil, true, "./output/step2.1", manifest)
+	if len(again.Images[0].Enhancements) != len(enhanced.Enhancements) || again.Images[0].Enhancements[0] != enhanced.Enhancements[0] {
+		t.Errorf("Expected the same seed to make the same decisions, got %+v and %+v", enhanced.Enhancements, again.Images[0].Enhancements)
+	}
+
+	dryRun.Templates = append(dryRun.Templates, "not-a-template")
+	dryRun.CheckTemplates(manifest)
+	if len(dryRun.MissingTemplates) != 1 || dryRun.MissingTemplates[0] != "not-a-template" {
+		t.Errorf("Expected only the unknown template to be missing, got %v", dryRun.MissingTemplates)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,920 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/beeep"
+	"github.com/go-vgo/robotgo"
+	cli "github.com/spf13/cobra"
+	"github.com/vcaesar/gcv"
+)
+
+const (
+	// Characters younger than this are never enhanced by the default safety policy.
+	enhanceMinAge = 16
+	// The Save button is clicked this many times before the image is given up on.
+	saveAttempts = 5
+	// An enhancement that makes no visible change to the face is applied this many times before it is marked unverified.
+	enhancementApplyAttempts = 2
+	// The least difference in the face region of the editor, before and after Apply, that counts as a visible change.
+	enhancementMinChange = 0.01
+	// The back button is clicked this many times to escape a screen that cannot be navigated home from, before the run is stopped.
+	recoveryBackClicks = 3
+	// A face refused by FaceApp throttling is retried this many times before the refusal counts as a failure, so that a face cannot be retried forever.
+	throttleMaxRetries = 5
+)
+
+// Returned by a FaceSource once it has no more faces.
+var ErrNoMoreFaces = errors.New("No more faces to enhance")
+
+// SourcedFace is a face found by a FaceSource, identified by the id of its source image.
+// Coords and Rect are only set by sources that find the face on screen.
+type SourcedFace struct {
+	Index   int
+	ImageId string
+	Path    string
+	Coords  Coords
+	Rect    image.Rectangle
+}
+
+func (f SourcedFace) String() string {
+	return fmt.Sprintf("[Index %v Face %v]", f.Index, f.ImageId)
+}
+
+// FaceSource finds the faces to enhance and opens them in the FaceApp editor.
+// The enhancement engine does the rest -- the editor, enhancement, apply and save steps are the same whichever way a face was found.
+type FaceSource interface {
+	// Find and identify the next face. Returns ErrNoMoreFaces once there are none left. Any other error skips the face.
+	Next(ctx context.Context) (SourcedFace, error)
+	// Open the face in the FaceApp editor.
+	Open(ctx context.Context, face SourcedFace) error
+	// Queue the face to be sourced again, after it failed part way through enhancement.
+	Retry(face SourcedFace)
+	// The screen to return to between faces.
+	Home() ScreenState
+}
+
+// RateLimitedSource is a FaceSource that holds faces back to a rate limit as it opens them, and adapts the rate to FaceApp throttling.
+type RateLimitedSource interface {
+	FaceSource
+	// The earliest time the next face can be opened.
+	NextSlot() time.Time
+	// Back off after FaceApp refused to enhance a face. Returns the time now left between faces.
+	Throttled() time.Duration
+	// Ramp back up after a face was enhanced. Returns the time now left between faces.
+	Succeeded() time.Duration
+}
+
+// EnhancementEngine applies FaceApp enhancements to each face of a FaceSource, saving the enhanced faces to the run's output directory.
+type EnhancementEngine struct {
+	Command       string
+	BlueStacks    *BlueStacks
+	OutputDir     string
+	Journal       *RunJournal
+	FacedataPaths []string
+	Plan          *EnhancementPlan // Enhancements decided ahead of the run. Faces are planned as they are enhanced when nil.
+	Quotas        *QuotaTracker    // Enhancements of the collection so far, counted across runs.
+	Recovery      *RecoveryPolicy  // Retries and quarantines faces that fail part way through enhancement.
+	Status        *RunStatus       // Progress of the run for the status endpoint. Nil unless --status-addr is set.
+
+	detectedEnhancedFaces []image.Rectangle // Cache of faces saved in post-save screen
+	enhancedCount         int               // Faces enhanced in this run, not counting those of the run being resumed
+	skipped               []SkippedImage    // Faces decided against, written to skipped.json for the run report
+	statusServer          *StatusServer
+	recorder              *RecordingDriver // Session being recorded with --record, if any
+	throttledAttempts     map[string]int   // Times each face was refused by FaceApp throttling
+}
+
+// Set up an enhancement run from the flags shared by the enhance commands.
+func NewEnhancementEngine(cmd *cli.Command, driver ScreenDriver) *EnhancementEngine {
+	var err error
+
+	debugMode, _ = cmd.Flags().GetBool("debug")
+	coordsCachePath, _ := cmd.Flags().GetString("coords-cache")
+	outputParentDir, _ := cmd.Flags().GetString("output")
+	resumeDir, _ := cmd.Flags().GetString("resume")
+	facedataDir, _ := cmd.Flags().GetString("facedata")
+	maxRetries, _ := cmd.Flags().GetInt("max-retries")
+	statusAddr, _ := cmd.Flags().GetString("status-addr")
+	if debugMode {
+		err = os.MkdirAll(fmt.Sprintf("./tmp/enhance-debug/%d", currentTs), 0755) // Create tmp dir for this debug dump
+		if err != nil {
+			logger.Step("setup").Fatalf("%v", err)
+		}
+		logger.Step("setup").Infof("Start enhancement in debug mode...")
+	} else {
+		logger.Step("setup").Infof("Start enhancement...")
+	}
+
+	// Create output directory -- or reuse the directory of the run being resumed
+	outputDir, journal, err := openRunOutput(outputParentDir, resumeDir)
+	if err != nil {
+		logger.Step("setup").Fatalf("%v", err)
+	}
+	// Log to the run directory as well, unless --log-file is set. A resumed run appends to the log it started with.
+	err = runLog.OpenFile(path.Join(outputDir, runLogFile))
+	if err != nil {
+		logger.Step("setup").Warnf("Cannot open run log - %v", err.Error())
+	}
+
+	// Images that failed every attempt in the run being resumed stay quarantined, unless they are enhanced this time
+	recovery, err := NewRecoveryPolicy(outputDir, maxRetries)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot load failed images - %v", err.Error())
+	}
+
+	skipped, err := LoadRunSkipped(outputDir)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot load skipped images - %v", err.Error())
+	}
+
+	// Setup Face Analysis Data Paths - Fetch all the JSON paths from the facedata directory
+	facedataPaths, err := filepath.Glob(path.Join(facedataDir, "/*.json"))
+	if err != nil {
+		logger.Step("setup").Fatalf("%v", err.Error())
+	}
+
+	// The recording is closed with the engine, so that the end of the session log is written
+	recorder, _ := driver.(*RecordingDriver)
+
+	// Serve the progress of the run, with the last screenshot the driver captured
+	var status *RunStatus
+	var statusServer *StatusServer
+	if statusAddr != "" {
+		status = NewRunStatus(cmd.CommandPath())
+		driver = &StatusDriver{ScreenDriver: driver, Status: status}
+		statusServer, err = StartStatusServer(statusAddr, status)
+		if err != nil {
+			logger.Step("setup").Fatalf("Cannot serve status - %v", err.Error())
+		}
+		logger.Step("setup").Infof("Serving status on http://%v", statusServer.Addr)
+	}
+
+	// Setup Bluestacks
+	bluestacks := NewBlueStacks(driver)
+	displayScale, _ := cmd.Flags().GetFloat64("display-scale")
+	bluestacks.SetDisplayScale(displayScale)
+
+	logger.Step("setup").Infof("Screen size %v x %v", bluestacks.ScreenWidth, bluestacks.ScreenHeight)
+
+	err = bluestacks.LoadAssets(faceappAssetsDir)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot load asset manifest - %v", err.Error())
+	}
+
+	// Enhancements are chosen from the catalogue -- with every probability maxed out in debug mode -- for the images the safety policy allows
+	catalogue := useEnhancementCatalogueFlag(cmd, bluestacks.Assets)
+	useSafetyPolicyFlag(cmd)
+	err = saveRunEnhancementCatalogue(catalogue, outputDir)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot save enhancement catalogue - %v", err.Error())
+	}
+
+	// Quotas count the images enhanced in previous runs
+	indexPaths, err := runIndexPaths(outputParentDir, resumeDir)
+	if err != nil {
+		logger.Step("setup").Fatalf("%v", err.Error())
+	}
+	quotas, err := LoadQuotaTracker(indexPaths)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot count enhancements of previous runs - %v", err.Error())
+	}
+
+	err = bluestacks.LoadCoordsCache(coordsCachePath)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot load coordinates cache - %v", err.Error())
+	}
+
+	bluestacks.FaceDetector, err = newFaceDetector(cmd)
+	if err != nil {
+		logger.Step("setup").Fatalf("%v", err.Error())
+	}
+
+	return &EnhancementEngine{
+		Command:       cmd.CommandPath(),
+		BlueStacks:    bluestacks,
+		OutputDir:     outputDir,
+		Journal:       journal,
+		FacedataPaths: facedataPaths,
+		Quotas:        quotas,
+		Recovery:      recovery,
+		Status:        status,
+		skipped:       skipped,
+		statusServer:  statusServer,
+		recorder:      recorder,
+	}
+}
+
+// Save the catalogue a run uses into its output directory. A resumed run keeps the catalogue it started with, and saves its own alongside it.
+func saveRunEnhancementCatalogue(catalogue *EnhancementCatalogue, outputDir string) error {
+	cataloguePath := path.Join(outputDir, enhancementCatalogueRunFile)
+	if _, err := os.Stat(cataloguePath); err == nil {
+		cataloguePath = path.Join(outputDir, fmt.Sprintf("enhancements-%d.json", currentTs))
+	}
+	return catalogue.Save(cataloguePath)
+}
+
+func (e *EnhancementEngine) Close() {
+	_ = e.BlueStacks.FaceDetector.Close()
+	_ = e.Journal.Close()
+	if e.statusServer != nil {
+		_ = e.statusServer.Close()
+	}
+	if e.recorder != nil {
+		_ = e.recorder.Close()
+	}
+	e.BlueStacks.coordsCache().Save()
+}
+
+// Enhance every face of the source.
+// When ctx is interrupted, the face being enhanced is finished -- or left for the resumed run, if a wait for the app was cut short -- and the run stops with a checkpoint.
+func (e *EnhancementEngine) Run(ctx context.Context, source FaceSource) {
+	for !interrupted(ctx) {
+		face, err := source.Next(ctx)
+		if errors.Is(err, ErrNoMoreFaces) {
+			break
+		}
+		if err != nil {
+			logger.Step("source").Warnf("Cannot source the next face - %v", err.Error())
+		} else if err = e.enhanceFace(ctx, source, face); err != nil {
+			if interrupted(ctx) {
+				logger.Face(face).Step("run").Infof("Interrupted before the image was enhanced - it is enhanced when the run is resumed")
+			} else if !e.throttle(source, face, err) {
+				e.recoverFace(source, face, err)
+			}
+		} else {
+			if err = e.Recovery.Succeed(face.ImageId); err != nil {
+				logger.Face(face).Step("recover").Warnf("Cannot update failed images - %v", err.Error())
+			}
+			e.rampUp(source)
+		}
+		// Every face ends back at the source's home screen, whether it was enhanced, skipped or failed -- so that an interrupted run leaves no modal open
+		err = e.returnHome(source)
+		if err != nil {
+			logger.Step("home").Errorf("Cannot recover to the home screen, stopping the run - %v", err.Error())
+			break
+		}
+	}
+
+	e.writeImageIndex()
+	for _, unmet := range e.Quotas.Unmet() {
+		logger.Step("run").Warnf("%v", unmet)
+	}
+	for _, summary := range e.Recovery.Summary() {
+		logger.Step("run").Infof("%v", summary)
+	}
+
+	if interrupted(ctx) {
+		e.checkpoint()
+		return
+	}
+	// Desktop notification of completion
+	_ = beeep.Notify("Automatically Animated", "Enhancement script is complete", "")
+}
+
+// Write a checkpoint of an interrupted run into its output directory. The journal already holds every face enhanced, so the run is resumed from its output directory.
+func (e *EnhancementEngine) checkpoint() {
+	checkpoint := RunCheckpoint{
+		Command:     e.Command,
+		StoppedAt:   time.Now(),
+		Done:        len(imageIndex),
+		Quarantined: len(e.Recovery.Quarantined),
+		Resume:      "--resume " + e.OutputDir,
+	}
+	checkpointPath := path.Join(e.OutputDir, runCheckpointFile)
+	err := checkpoint.Save(checkpointPath)
+	if err != nil {
+		logger.Step("run").Warnf("Cannot write checkpoint - %v", err.Error())
+	} else {
+		logger.Step("run").Infof("Checkpoint written to %v", checkpointPath)
+	}
+	notifyInterrupted(fmt.Sprintf("%d images enhanced (%d this run), %d quarantined. Resume with %v %v", len(imageIndex), e.enhancedCount, len(e.Recovery.Quarantined), e.Command, checkpoint.Resume))
+}
+
+// Expect the images the run will enhance, so that quotas can be met across them. Images already enhanced, without facedata or ruled out by the safety policy are not expected.
+func (e *EnhancementEngine) ExpectImages(imageIds []string) {
+	for _, imageId := range imageIds {
+		if e.alreadyEnhanced(imageId) {
+			continue
+		}
+		facedata, err := e.faceData(imageId)
+		if err != nil || safetyPolicy.Check(imageId, facedata) != "" {
+			continue
+		}
+		e.Quotas.Expect(imageId, facedata.FaceDetails[0])
+	}
+}
+
+// Return to the source's home screen. When navigation fails, back out of the current screen and try again before giving up.
+func (e *EnhancementEngine) returnHome(source FaceSource) error {
+	bluestacks := e.BlueStacks
+	err := bluestacks.NavigateTo(source.Home())
+	for attempt := 1; err != nil && attempt <= recoveryBackClicks; attempt++ {
+		logger.Step("home").Warnf("Cannot return home (%v) - backing out, attempt %d of %d", err.Error(), attempt, recoveryBackClicks)
+		_ = bluestacks.OsBackClick()
+		bluestacks.Driver.MilliSleep(1000)
+		err = bluestacks.NavigateTo(source.Home())
+	}
+	return err
+}
+
+// Back off when a face failed because FaceApp is throttling the run, which shows as its error or try again later screen.
+// The face is retried without counting against its retries, as it was refused rather than failed -- up to throttleMaxRetries times, after which it is recovered like any other failure.
+// Returns false when FaceApp is not throttling, or the source cannot back off.
+func (e *EnhancementEngine) throttle(source FaceSource, face SourcedFace, failure error) bool {
+	bluestacks := e.BlueStacks
+	// Without throttled markers in the asset pack the screen cannot be recognised, so it is not captured
+	if len(bluestacks.assets().Markers(StateThrottled)) == 0 {
+		return false
+	}
+	if bluestacks.ClassifyScreen(bluestacks.Driver.CaptureImg()) != StateThrottled {
+		return false
+	}
+	limited, ok := source.(RateLimitedSource)
+	if !ok {
+		logger.Face(face).Step("throttle").Warnf("FaceApp is throttling - %v", failure.Error())
+		return false
+	}
+	interval := limited.Throttled()
+	if !e.retryThrottled(face) {
+		logger.Face(face).Step("throttle").With("interval", interval.String()).Warnf("FaceApp is throttling (%v) - refused the image %d times, so it counts as failed", failure.Error(), throttleMaxRetries+1)
+		e.Status.SetNextSlot(limited.NextSlot())
+		return false
+	}
+	logger.Face(face).Step("throttle").With("interval", interval.String()).With("nextSlot", limited.NextSlot()).Warnf("FaceApp is throttling (%v) - backing off to one image every %v", failure.Error(), interval)
+	source.Retry(face)
+	e.Status.Retried()
+	e.Status.SetNextSlot(limited.NextSlot())
+	return true
+}
+
+// Count a refusal of the face, returning whether it can be retried without counting against its retries.
+func (e *EnhancementEngine) retryThrottled(face SourcedFace) bool {
+	if e.throttledAttempts == nil {
+		e.throttledAttempts = map[string]int{}
+	}
+	e.throttledAttempts[face.ImageId]++
+	return e.throttledAttempts[face.ImageId] <= throttleMaxRetries
+}
+
+// Ramp the rate of a source back up after a face was enhanced.
+func (e *EnhancementEngine) rampUp(source FaceSource) {
+	limited, ok := source.(RateLimitedSource)
+	if !ok {
+		return
+	}
+	interval := limited.Succeeded()
+	e.Status.SetNextSlot(limited.NextSlot())
+	logger.Step("throttle").With("interval", interval.String()).Debugf("Importing one image every %v", interval)
+}
+
+// Capture the screen a face failed on, then retry the face or quarantine it once it has no retries left.
+// The caller returns to the home screen afterwards, so that the run can carry on.
+func (e *EnhancementEngine) recoverFace(source FaceSource, face SourcedFace, failure error) {
+	logger.Face(face).Step("recover").Errorf("%v", failure.Error())
+	screenshotPath := e.captureFailure(face)
+	retry, err := e.Recovery.Fail(face, failure, screenshotPath)
+	if err != nil {
+		logger.Face(face).Step("recover").Warnf("Cannot update failed images - %v", err.Error())
+	}
+	if retry {
+		source.Retry(face)
+		e.Status.Retried()
+		logger.Face(face).Step("recover").Infof("Added back into loop - retry %d of %d", e.Recovery.Attempts(face.ImageId), e.Recovery.MaxRetries)
+		return
+	}
+	e.Status.Failed()
+	logger.Face(face).Step("recover").Warnf("Quarantined after %d attempts", e.Recovery.Attempts(face.ImageId))
+}
+
+// Write a diagnostic screenshot of a failed face to the run's output directory. Returns the path, or an empty string when it cannot be written.
+func (e *EnhancementEngine) captureFailure(face SourcedFace) string {
+	failuresDir := path.Join(e.OutputDir, failureScreenshotsDir)
+	err := os.MkdirAll(failuresDir, 0755)
+	if err != nil {
+		logger.Face(face).Step("recover").Warnf("Cannot capture failure - %v", err.Error())
+		return ""
+	}
+	screenshotPath := path.Join(failuresDir, fmt.Sprintf("%v-%d.jpeg", face.ImageId, time.Now().UnixNano()))
+	if !gcv.ImgWrite(screenshotPath, e.BlueStacks.Driver.CaptureImg()) {
+		logger.Face(face).Step("recover").Warnf("Cannot capture failure to %v", screenshotPath)
+		return ""
+	}
+	return screenshotPath
+}
+
+func (e *EnhancementEngine) alreadyEnhanced(imageId string) bool {
+	for _, indexedImage := range imageIndex {
+		if indexedImage.Id == imageId {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch the face analysis data of an image from the facedata directory.
+func (e *EnhancementEngine) faceData(imageId string) (FaceData, error) {
+	return loadFaceData(e.FacedataPaths, imageId)
+}
+
+// The planned enhancements of an image. Without a plan, the image is planned from its facedata with a seed derived from the run, which is logged so the choice can be reproduced.
+// Images that cannot be planned are planned to be skipped, with the reason code, and the error explaining it.
+func (e *EnhancementEngine) planFace(imageId string, facedata FaceData) (PlannedImage, error) {
+	if e.Plan != nil {
+		planned, found := e.Plan.Image(imageId)
+		if !found {
+			return PlannedImage{Id: imageId, Skip: DryRunSkipNotPlanned}, fmt.Errorf("Image %v is not in the plan", imageId)
+		}
+		return planned, nil
+	}
+	planned := planImage(imageSeed(currentTs, imageId), imageId, facedata, e.Quotas)
+	logger.Image(imageId).Step("plan").With("seed", planned.Seed).Infof("Planned with seed %d", planned.Seed)
+	return planned, nil
+}
+
+// Enhance a face from its source, leaving it on whichever screen it ends on. Faces that are skipped are not an error.
+// Returns an error when the face failed part way through, so that it can be recovered.
+func (e *EnhancementEngine) enhanceFace(ctx context.Context, source FaceSource, face SourcedFace) error {
+	bluestacks := e.BlueStacks
+	e.Status.Sourced(face)
+
+	// Continue with the next face if this face has already been enhanced.
+	if e.alreadyEnhanced(face.ImageId) {
+		logger.Face(face).Step("check").Infof("Image has already been enhanced")
+		e.Status.AlreadyEnhanced()
+		return nil
+	}
+
+	// 1. Skip faces the safety policy rules out, such as underage characters -- whatever a plan says, as plans are edited by hand and may be older than the exclusion lists
+	facedata, err := e.faceData(face.ImageId)
+	if err != nil {
+		logger.Face(face).Step("policy").With("reason", PolicySkipNoFacedata).Warnf("%v", err.Error())
+		e.skip(face, PolicySkipNoFacedata, err.Error())
+		return nil
+	}
+	if reason := safetyPolicy.Check(face.ImageId, facedata); reason != "" {
+		logger.Face(face).Step("policy").With("reason", reason).Infof("Image is ruled out by the safety policy (%v). Skipping enhancement...", reason)
+		e.skip(face, reason, "")
+		return nil
+	}
+
+	// 2. Decide the enhancements of the face
+	planned, err := e.planFace(face.ImageId, facedata)
+	if err != nil {
+		logger.Face(face).Step("plan").With("reason", planned.Skip).Warnf("%v", err.Error())
+		e.skip(face, planned.Skip, err.Error())
+		return nil
+	}
+	if planned.Skip != "" {
+		logger.Face(face).Step("plan").With("reason", planned.Skip).Infof("Image is planned to be skipped (%v). Skipping enhancement...", planned.Skip)
+		e.skip(face, planned.Skip, "")
+		return nil
+	}
+	selectedEnhancements, err := planned.Selected()
+	if err != nil {
+		logger.Face(face).Step("plan").With("reason", PlanSkipInvalid).Warnf("%v", err.Error())
+		e.skip(face, PlanSkipInvalid, err.Error())
+		return nil
+	}
+
+	// 3. Open the face in the editor
+	err = source.Open(ctx, face)
+	if err != nil {
+		return fmt.Errorf("Cannot open image - %v", err.Error())
+	}
+	if limited, ok := source.(RateLimitedSource); ok {
+		e.Status.SetNextSlot(limited.NextSlot())
+	}
+
+	// 4. Wait for the an enhancement to show
+	_, err = bluestacks.WaitFor(ctx, enhancementElement(enhancements[0].Name), enhancementLoadTimeout, 2*time.Second)
+	// Fail the image if it has not been detected -- Could becasue FaceApp failed to detect the image too
+	if err != nil {
+		return fmt.Errorf("No enhancements detected after selection - %v", err.Error())
+	}
+
+	logger.Face(face).Step("editor").Infof("Starting enhancement...")
+
+	err = e.selectFemaleInterface()
+	if err != nil {
+		return err
+	}
+
+	// 5. Run the enhancement process here.
+
+	// 5.1. Determine the enhancements
+	// -- 5.1.1. Check if user has beard -- add beard. -- random selection of beard type depending on if mustache/beard
+	// -- 5.1.2. Check if user has glasses -- add glasses -- random selection
+	// -- 5.1.3. Check if female, and probability for make up -- add make up -- random selection
+	// -- 5.1.4. Plus size the person by chance too -- there should be heavier people.
+	// 5.2. Iterate and apply the enhancements
+	// -- 5.2.1. Select enhancement
+	// -- 5.2.2. Wait for the processing text to no longer show
+	// -- 5.2.3. Select the Apply text
+	// -- 5.2.4. Select the Save text
+	// -- 5.2.5. Detect the image inside of the Save Screen
+	// -- 5.2.6. Click the back button -- to get back to the Editor
+
+	//* ENHANCEMENT PROCESS
+	enhancementsApplied := []map[string]string{}
+	for _, selected := range selectedEnhancements {
+		applied, verified, err := e.applyVerifiedEnhancement(face, selected.Enhancement, selected.Type)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			continue
+		}
+		enhancementApplied := map[string]string{
+			"name": selected.Enhancement.Name,
+			"type": selected.Type.Name,
+		}
+		if !verified {
+			// Keep the record of the attempt, but so that it is not taken as a trait of the image
+			enhancementApplied["unverified"] = "true"
+		}
+		enhancementsApplied = append(enhancementsApplied, enhancementApplied)
+	}
+
+	//* SAVING PROCESS
+	enhancedFaceImgPath := ""
+	if len(enhancementsApplied) > 0 {
+		enhancedFaceImgPath, err = e.save(face)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Face(face).Step("index").Infof("%d enhancements made", len(enhancementsApplied))
+
+	e.enhancedCount++
+	e.Status.Enhanced()
+	imageIndex = append(imageIndex, IndexedImage{
+		Id:                face.ImageId,
+		Enhancements:      enhancementsApplied,
+		EnhancedImagePath: enhancedFaceImgPath,
+	})
+	e.Quotas.Record(imageIndex[len(imageIndex)-1])
+	err = e.Journal.Append(imageIndex[len(imageIndex)-1])
+	if err != nil {
+		logger.Face(face).Step("index").Fatalf("%v", err.Error())
+	}
+	e.writeImageIndex()
+	return nil
+}
+
+// Record a face that was decided against with the reason code, and any detail of why, replacing any earlier reason it was skipped for.
+func (e *EnhancementEngine) skip(face SourcedFace, reason, detail string) {
+	skipped := []SkippedImage{}
+	for _, s := range e.skipped {
+		if s.Id != face.ImageId {
+			skipped = append(skipped, s)
+		}
+	}
+	e.skipped = append(skipped, SkippedImage{Id: face.ImageId, Reason: reason, Detail: detail})
+	e.Status.Skipped()
+	err := saveRunSkipped(e.OutputDir, e.skipped)
+	if err != nil {
+		logger.Face(face).Step("plan").Warnf("Cannot update skipped images - %v", err.Error())
+	}
+}
+
+// Ensure that the Female Gender Controls are Activated
+func (e *EnhancementEngine) selectFemaleInterface() error {
+	bluestacks := e.BlueStacks
+	editorScreenImg := bluestacks.Driver.CaptureImg()
+	genderSwitchIconCoords, err := bluestacks.GetDerivedCoordsWithCache(func() (CoordsCacheEntry, error) {
+		coords, imagePath, _, err := bluestacks.locate("editor-header", editorScreenImg, 0)
+		if err != nil {
+			return CoordsCacheEntry{}, err
+		}
+		editorHeaderImg, _, err := robotgo.DecodeImg(imagePath)
+		if err != nil {
+			return CoordsCacheEntry{}, fmt.Errorf("%v: %s", err, imagePath)
+		}
+		genderSwitchIconImg, err := bluestacks.assets().DecodeImage("gender-switch-icon")
+		if err != nil {
+			return CoordsCacheEntry{}, err
+		}
+		pointInImage := bluestacks.GetImagePoint(coords, editorScreenImg)
+		genderSwitchXPointInImage := pointInImage.X + editorHeaderImg.Bounds().Dx()/2 - genderSwitchIconImg.Bounds().Dx()/2
+		return CoordsCacheEntry{
+			Coords: Coords{
+				X: bluestacks.GetCoords(genderSwitchXPointInImage, pointInImage.Y, editorScreenImg).X,
+				Y: coords.Y,
+			},
+			Template: imagePath,
+			Anchor:   &coords,
+		}, nil
+	}, "editor-gender-switch-icon", editorScreenImg)
+	if err != nil {
+		return fmt.Errorf("Cannot select gender switch icon - %v", err.Error())
+	}
+	bluestacks.MoveClick(genderSwitchIconCoords.X, genderSwitchIconCoords.Y)
+	bluestacks.Driver.MilliSleep(250)
+	editorScreenImg = bluestacks.Driver.CaptureImg()
+	genderSwitchOptionCoords, err := bluestacks.LocateWithCache("gender-switch-female-option", editorScreenImg, "editor-gender-switch-option")
+	if err != nil {
+		return fmt.Errorf("Cannot select gender switch option - %v", err.Error())
+	}
+	bluestacks.MoveClick(genderSwitchOptionCoords.X, genderSwitchOptionCoords.Y)
+	bluestacks.Driver.MilliSleep(250)
+	return nil
+}
+
+// SelectedEnhancement is an enhancement chosen for a face, along with the type of it to apply.
+type SelectedEnhancement struct {
+	Enhancement Enhancement
+	Type        EnhancementType
+}
+
+// Choose the enhancements for a face, and a type of each.
+// The rules of each enhancement decide whether it is eligible for the face, forced onto it or reweighted. Other enhancements are chosen by their probability.
+// Enhancements and types whose quota is full are never chosen, and those whose exact quota is due are forced.
+// All randomness comes from r, so the same seed always chooses the same enhancements.
+func chooseEnhancements(faceDetails types.FaceDetail, r *rand.Rand, quotas *QuotaTracker) []SelectedEnhancement {
+	var selected []SelectedEnhancement
+	for _, enhancement := range enhancements {
+		outcome := enhancement.Evaluate(faceDetails)
+		if !outcome.Eligible || quotas.Full(enhancement.Quota, quotaKey(enhancement.Name, "")) {
+			continue
+		}
+		applyEnhancement := outcome.Forced || quotas.Due(enhancement.Quota, quotaKey(enhancement.Name, "")) || typeQuotaDue(enhancement, faceDetails, quotas)
+		if !applyEnhancement {
+			// Apply probabilty for enhancement
+			applyEnhancement = r.Float64() <= math.Min(1, enhancement.Probability*outcome.Weight)
+		}
+		if !applyEnhancement {
+			continue
+		}
+		eType, found := chooseEnhancementType(enhancement, faceDetails, r, quotas)
+		if !found {
+			continue
+		}
+		selected = append(selected, SelectedEnhancement{
+			Enhancement: enhancement,
+			Type:        eType,
+		})
+	}
+	return selected
+}
+
+// Whether the exact quota of an eligible type of the enhancement is due, which forces the enhancement too.
+func typeQuotaDue(enhancement Enhancement, faceDetails types.FaceDetail, quotas *QuotaTracker) bool {
+	for _, eType := range enhancement.Types {
+		if quotas.Due(eType.Quota, quotaKey(enhancement.Name, eType.Name)) && eType.Evaluate(faceDetails).Eligible {
+			return true
+		}
+	}
+	return false
+}
+
+// Select the type of enhancement by weighted sampling -- each eligible type is chosen in proportion to its probability, after its rules reweight it.
+// Type probabilities are relative weights, so they do not need to add up to 1.
+// A type forced by its rules or due by its quota is chosen over the others, in catalogue order. Types whose quota is full are never chosen.
+// Returns false when no type can be chosen.
+func chooseEnhancementType(enhancement Enhancement, faceDetails types.FaceDetail, r *rand.Rand, quotas *QuotaTracker) (EnhancementType, bool) {
+	var candidates []EnhancementType
+	totalWeight := 0.0
+	for _, eType := range enhancement.Types {
+		outcome := eType.Evaluate(faceDetails)
+		key := quotaKey(enhancement.Name, eType.Name)
+		if !outcome.Eligible || quotas.Full(eType.Quota, key) {
+			continue
+		}
+		if outcome.Forced || quotas.Due(eType.Quota, key) {
+			return eType, true
+		}
+		eType.Probability = eType.Probability * outcome.Weight
+		if eType.Probability > 0 {
+			candidates = append(candidates, eType)
+			totalWeight += eType.Probability
+		}
+	}
+	if len(candidates) == 0 {
+		return EnhancementType{}, false
+	}
+	pick := r.Float64() * totalWeight
+	for _, eType := range candidates {
+		if pick < eType.Probability {
+			return eType, true
+		}
+		pick -= eType.Probability
+	}
+	return candidates[len(candidates)-1], true // Rounding error
+}
+
+// Apply an enhancement, then check that it made a visible change to the face in the editor.
+// An enhancement that made no change is applied again, up to enhancementApplyAttempts times. Returns whether it was applied at all, and whether a change was seen.
+// Returns an error when the editor could not be used to apply it, which fails the face.
+func (e *EnhancementEngine) applyVerifiedEnhancement(face SourcedFace, enhancement Enhancement, eType EnhancementType) (bool, bool, error) {
+	bluestacks := e.BlueStacks
+	beforeImg := bluestacks.Driver.CaptureImg()
+	faceRect := e.editorFaceRect(beforeImg)
+	applied := false
+	for attempt := 1; attempt <= enhancementApplyAttempts; attempt++ {
+		ok, err := e.applyEnhancement(face, enhancement, eType)
+		if err != nil {
+			return applied, false, err
+		}
+		if !ok {
+			return applied, false, nil
+		}
+		applied = true
+		afterImg := bluestacks.Driver.CaptureImg()
+		change := imageDifference(beforeImg, afterImg, faceRect)
+		if change >= enhancementMinChange {
+			logger.Face(face).Step("verify").Infof("Enhancement %v : %v verified (change %.3f)", enhancement.Name, eType.Name, change)
+			return true, true, nil
+		}
+		logger.Face(face).Step("verify").Warnf("Enhancement %v : %v made no visible change (%.3f) - attempt %d of %d", enhancement.Name, eType.Name, change, attempt, enhancementApplyAttempts)
+	}
+	return applied, false, nil
+}
+
+// The region of the face in an editor screenshot -- the largest face detected, or the whole screenshot when none is.
+func (e *EnhancementEngine) editorFaceRect(editorScreenImg image.Image) image.Rectangle {
+	faceRect := editorScreenImg.Bounds()
+	largest := 0
+	for _, detection := range e.BlueStacks.DetectFaces(editorScreenImg, faceMinWidth) {
+		if area := detection.Rect.Dx() * detection.Rect.Dy(); area > largest {
+			faceRect, largest = detection.Rect, area
+		}
+	}
+	return faceRect
+}
+
+// Select an enhancement and one of its types, then apply it. Returns false if the enhancement or type could not be selected, and an error if it could not be applied.
+func (e *EnhancementEngine) applyEnhancement(face SourcedFace, enhancement Enhancement, eType EnhancementType) (bool, error) {
+	bluestacks := e.BlueStacks
+
+	// proceed with enhancement
+	editorScreenImg := bluestacks.Driver.CaptureImg()
+	logger.Face(face).Step("apply").Infof("Entering into enhancement %s ...", enhancement.Name)
+	eCoords, err := bluestacks.LocateWithCache(enhancementElement(enhancement.Name), editorScreenImg, fmt.Sprintf("enhancement-%s", enhancement.Name))
+	if err != nil {
+		logger.Face(face).Step("apply").Errorf("Cannot select enhancement %s - %v", enhancement.Name, err.Error())
+		return false, nil
+	}
+	bluestacks.MoveClick(eCoords.X, eCoords.Y)
+	bluestacks.Driver.MilliSleep(1000)
+	logger.Face(face).Step("apply").Infof("Entered into enhancement %s", enhancement.Name)
+
+	editorScreenImg = bluestacks.Driver.CaptureImg()
+	if eType.ScrollRequirement > 0 {
+		var scrollReferenceEnhancementType EnhancementType
+		for _, t := range enhancement.Types {
+			if t.ScrollRequirement == 0 {
+				scrollReferenceEnhancementType = t
+				break
+			}
+		}
+		logger.Face(face).Step("apply").Infof("Finding scroll reference of type %s to find enhancement %s type %s ...", scrollReferenceEnhancementType.Name, enhancement.Name, eType.Name)
+		etCoords, err := bluestacks.LocateWithCache(enhancementTypeElement(enhancement.Name, scrollReferenceEnhancementType.Name), editorScreenImg, fmt.Sprintf("enhancement-type-%s", scrollReferenceEnhancementType.Name))
+		if err != nil {
+			logger.Face(face).Step("apply").Errorf("Cannot find enhancement type %s for scroll reference - %v", scrollReferenceEnhancementType.Name, err.Error())
+			return false, e.exitEnhancement()
+		}
+		scrollIterations := int(math.Round(float64(eType.ScrollRequirement) / 200.0))
+		for s := 0; s < scrollIterations; s++ {
+			bluestacks.Driver.Move(bluestacks.CenterCoords.X, etCoords.Y)
+			bluestacks.Driver.MilliSleep(500)
+			bluestacks.Driver.DragSmooth(bluestacks.CenterCoords.X-200, etCoords.Y)
+		}
+		bluestacks.Driver.MilliSleep(1000)
+		editorScreenImg = bluestacks.Driver.CaptureImg() // Re-capture after the enhancement type horizontal scroll
+		logger.Face(face).Step("apply").Infof("Horizontal scroll to find enhancement %s type %s", enhancement.Name, scrollReferenceEnhancementType.Name)
+	}
+	if debugMode {
+		go func() {
+			gcv.ImgWrite(fmt.Sprintf("./tmp/enhance-debug/%d/editor-screen-%s--%d.jpg", currentTs, eType.Name, time.Now().Unix()), editorScreenImg)
+		}()
+	}
+
+	logger.Face(face).Step("apply").Infof("Attempting to enhance using enhancement %s type %s ...", enhancement.Name, eType.Name)
+	etCoords, err := bluestacks.LocateWithCache(enhancementTypeElement(enhancement.Name, eType.Name), editorScreenImg, fmt.Sprintf("enhancement-type-%s", eType.Name))
+	if err != nil {
+		logger.Face(face).Step("apply").Errorf("Cannot find enhancement type %s - %v", eType.Name, err.Error())
+		return false, e.exitEnhancement()
+	}
+	bluestacks.MoveClick(etCoords.X, etCoords.Y)
+	logger.Face(face).Step("apply").Infof("Enhanced using enhancement %s type %s", enhancement.Name, eType.Name)
+	applyCoords, err := bluestacks.LocateWithCache("apply", editorScreenImg, "editor-apply")
+	if err != nil {
+		return false, fmt.Errorf("Cannot find Apply text/button - %v", err.Error())
+	}
+	bluestacks.MoveClick(applyCoords.X, applyCoords.Y)
+	bluestacks.Driver.Click()          // Double click to make sure....
+	bluestacks.Driver.MilliSleep(2000) // Wait for Apply and return to editor screen animation
+	logger.Face(face).Step("apply").Infof("Enhancement %v : %v applied", enhancement.Name, eType.Name)
+	return true, nil
+}
+
+// Exit from the enhancement type selection screen to the editor.
+// We don't go all the way back to the home screen here, because we're iterating over enhancements.
+func (e *EnhancementEngine) exitEnhancement() error {
+	err := e.BlueStacks.NavigateTo(StateEditor)
+	if err != nil {
+		return fmt.Errorf("Cannot exit enhancement - %v", err.Error())
+	}
+	return nil
+}
+
+// Save the enhanced image, then crop the enhanced face from the save screen into the output directory.
+// Returns an error when the face could not be saved.
+func (e *EnhancementEngine) save(face SourcedFace) (string, error) {
+	bluestacks := e.BlueStacks
+	editorScreenImg := bluestacks.Driver.CaptureImg()
+	saveCoords, err := bluestacks.LocateWithCache("save", editorScreenImg, "editor-save")
+	if err != nil {
+		return "", fmt.Errorf("Cannot find Save text/button - %v", err.Error())
+	}
+	isSaved := false
+	var postSaveImg image.Image
+	for saveCount := 0; saveCount < saveAttempts; saveCount++ {
+		bluestacks.MoveClick(saveCoords.X, saveCoords.Y)
+		bluestacks.Driver.Click()          // Double click to make sure...
+		bluestacks.Driver.MilliSleep(2000) // Wait for the save button to disappear
+		postSaveImg = bluestacks.Driver.CaptureImg()
+		if !imagesSimilar(editorScreenImg, postSaveImg) {
+			isSaved = true
+			break
+		}
+	}
+	if !isSaved {
+		return "", fmt.Errorf("Failed to Save after %d attempts", saveAttempts)
+	}
+	logger.Face(face).Step("save").Infof("Saved!")
+
+	faceRect := faceRects(bluestacks.DetectFaces(postSaveImg, faceMinWidth))
+	// Cache the post-save face detection. This way we can fallback in the case the face detected is not at center of the screen, or if there are no faces detected.
+	if len(faceRect) != 1 {
+		if len(faceRect) > 1 {
+			// This was being hit due to the images inside of then Before & After image.
+			logger.Face(face).Step("save").Warnf("Detected multiple faces after enhancement...")
+		} else if len(faceRect) == 0 {
+			logger.Face(face).Step("save").Warnf("Cannot find Detected Enhanced Face...")
+		}
+		if len(e.detectedEnhancedFaces) == 0 {
+			return "", errors.New("No cached Detected Enhanced Face Coordinates to use")
+		}
+		logger.Face(face).Step("save").Infof("Using average cached Detected Enhanced Face Coordinates")
+		// Determine total rect from previously detected post-save faces
+		var totalRect image.Rectangle
+		for _, r := range e.detectedEnhancedFaces {
+			totalRect = image.Rectangle{
+				Min: r.Min.Add(totalRect.Min),
+				Max: r.Max.Add(totalRect.Max),
+			}
+		}
+		faceRect = []image.Rectangle{
+			image.Rectangle{Min: totalRect.Min.Div(len(e.detectedEnhancedFaces)), Max: totalRect.Max.Div(len(e.detectedEnhancedFaces))},
+		}
+	} else {
+		e.detectedEnhancedFaces = append(e.detectedEnhancedFaces, faceRect[0])
+	}
+	// Save detected enhanced face to output directory
+	enhancedFaceImg := imaging.Crop(postSaveImg, faceRect[0])
+	enhancedFaceImgPath := path.Join(e.OutputDir, fmt.Sprintf("%v.jpeg", face.ImageId))
+	go func() {
+		if gcv.ImgWrite(enhancedFaceImgPath, enhancedFaceImg) {
+			logger.Face(face).Step("save").Infof("Successfully saved detected enhanced image")
+		} else {
+			logger.Face(face).Step("save").Warnf("Failed to save detected enhanced image")
+		}
+	}()
+
+	// Use the back button to return to the Editor Screen -- the face is saved either way, and the engine recovers the home screen next
+	err = bluestacks.OsBackClick()
+	if err != nil {
+		logger.Face(face).Step("save").Warnf("Cannot go back from the Save Screen - %v", err.Error())
+	}
+	return enhancedFaceImgPath, nil
+}
+
+// Save Image Index to file
+// https://www.socketloop.com/tutorials/golang-save-map-struct-to-json-or-xml-file
+func (e *EnhancementEngine) writeImageIndex() {
+	imageIndexJson, err := json.Marshal(imageIndex)
+	if err != nil {
+		logger.Step("index").Fatalf("%v", err.Error())
+	}
+	jsonPath := path.Join(e.OutputDir, "index.json")
+	err = ioutil.WriteFile(jsonPath, imageIndexJson, 0644)
+	if err != nil {
+		logger.Step("index").Fatalf("%v", err.Error())
+	}
+	logger.Step("index").Infof("JSON data written to %v", jsonPath)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+func TestChooseEnhancementsBeardsForMustache(t *testing.T) {
+	faceDetails := types.FaceDetail{
+		Gender:   &types.Gender{Value: types.GenderTypeMale},
+		Beard:    &types.Beard{Value: false},
+		Mustache: &types.Mustache{Value: true},
+	}
+	for i := 0; i < 20; i++ {
+		selected := chooseEnhancements(faceDetails, rand.New(rand.NewSource(int64(i))), nil)
+		if len(selected) == 0 || selected[0].Enhancement.Name != "Beards" || selected[0].Type.Name == "" {
+			t.Fatalf("Expected a beard for a face with a mustache, got %+v", selected)
+		}
+	}
+
+	faceDetails.Gender.Value = types.GenderTypeFemale
+	for _, selected := range chooseEnhancements(faceDetails, rand.New(rand.NewSource(1)), nil) {
+		if selected.Enhancement.Name == "Beards" {
+			t.Fatal("Expected no beard for a female face")
+		}
+	}
+}
+
+func TestImportFaceSource(t *testing.T) {
+	source := &ImportFaceSource{
+		ImagePaths: []string{"./output/step2/1.jpeg", "./output/step2/2.jpeg", "./output/step2/3.jpeg"},
+	}
+	ctx := context.Background()
+	face, err := source.Next(ctx)
+	if err != nil || face.ImageId != "1" || face.Index != 0 {
+		t.Fatalf("Unexpected first face %+v - %v", face, err)
+	}
+	source.Retry(face)
+	var ids []string
+	for {
+		face, err := source.Next(ctx)
+		if errors.Is(err, ErrNoMoreFaces) {
+			break
+		}
+		ids = append(ids, face.ImageId)
+	}
+	if len(ids) != 3 || ids[0] != "2" || ids[1] != "3" || ids[2] != "1" {
+		t.Errorf("Expected the retried face last, got %v", ids)
+	}
+}
+
+type stubFaceDetector struct {
+	faces map[image.Image][]FaceDetection
+}
+
+func (d *stubFaceDetector) Detect(img image.Image) ([]FaceDetection, error) {
+	return d.faces[img], nil
+}
+
+func (d *stubFaceDetector) Close() error {
+	return nil
+}
+
+// Identifies a face by the colour at the middle of the searched image.
+type stubFaceSearcher struct {
+	ids map[color.RGBA]string
+}
+
+func (s *stubFaceSearcher) SearchFacesByImage(ctx context.Context, params *rekognition.SearchFacesByImageInput, optFns ...func(*rekognition.Options)) (*rekognition.SearchFacesByImageOutput, error) {
+	img, err := jpeg.Decode(bytes.NewReader(params.Image.Bytes))
+	if err != nil {
+		return nil, err
+	}
+	center := image.Pt((img.Bounds().Min.X+img.Bounds().Max.X)/2, (img.Bounds().Min.Y+img.Bounds().Max.Y)/2)
+	r, g, b, _ := img.At(center.X, center.Y).RGBA()
+	output := &rekognition.SearchFacesByImageOutput{}
+	for c, id := range s.ids {
+		if absDiff(uint32(c.R), r>>8) < 32 && absDiff(uint32(c.G), g>>8) < 32 && absDiff(uint32(c.B), b>>8) < 32 {
+			id := id
+			output.FaceMatches = append(output.FaceMatches, types.FaceMatch{
+				Similarity: aws.Float32(99),
+				Face:       &types.Face{ExternalImageId: &id},
+			})
+		}
+	}
+	return output, nil
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func countActions(driver *MemoryDriver, kind string) int {
+	count := 0
+	for _, action := range driver.Actions {
+		if action.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+func TestGalleryFaceSourceRetriesLastFaceOfSet(t *testing.T) {
+	galleryMarker := noiseImage(1, 20, 15)
+	pickerMarker := noiseImage(2, 20, 15)
+	manifest := writeTestAssetPack(t, map[string]image.Image{
+		"folder-filter.png":        galleryMarker,
+		"filepicker-indicator.png": pickerMarker,
+	}, `{"elements": [
+		{"name": "folder-filter", "images": [{"file": "folder-filter.png"}], "state": "gallery", "marker": true},
+		{"name": "filepicker-indicator", "images": [{"file": "filepicker-indicator.png"}], "state": "folder-picker", "marker": true}
+	]}`)
+
+	// A set of a single face, and the set of a single face that is scrolled to after it
+	red, green := color.RGBA{220, 30, 30, 255}, color.RGBA{30, 220, 30, 255}
+	faceA, faceB := image.Rect(20, 50, 70, 100), image.Rect(120, 75, 170, 125)
+	firstSet, secondSet, picker := noiseImage(10, 200, 150), noiseImage(11, 200, 150), noiseImage(12, 200, 150)
+	drawImage(firstSet, galleryMarker, image.Pt(170, 5))
+	drawImage(secondSet, galleryMarker, image.Pt(170, 5))
+	drawImage(picker, pickerMarker, image.Pt(90, 65))
+	fillRect(firstSet, faceA, red)
+	fillRect(secondSet, faceB, green)
+
+	// Each face returns to the SharedFolder through the folder picker -- gallery, gallery, folder picker, gallery -- before it scrolls and detects faces.
+	toSharedFolder := []image.Image{firstSet, firstSet, picker, firstSet}
+	var screens []image.Image
+	screens = append(screens, toSharedFolder...)
+	screens = append(screens, firstSet) // Detect the first set
+	screens = append(screens, toSharedFolder...)
+	screens = append(screens, firstSet) // Detect the retried face again, without scrolling
+	screens = append(screens, toSharedFolder...)
+	screens = append(screens, firstSet, secondSet, secondSet) // Scroll to, and detect, the second set
+	driver := &MemoryDriver{Width: 200, Height: 150, Screens: screens}
+
+	bluestacks := NewBlueStacks(driver)
+	bluestacks.Assets = manifest
+	bluestacks.FaceDetector = &stubFaceDetector{faces: map[image.Image][]FaceDetection{
+		firstSet:  {{Rect: faceA, Confidence: 1}},
+		secondSet: {{Rect: faceB, Confidence: 1}},
+	}}
+	source := &GalleryFaceSource{
+		BlueStacks:   bluestacks,
+		AWSClient:    &stubFaceSearcher{ids: map[color.RGBA]string{red: "a", green: "b"}},
+		CollectionId: "test",
+		index:        -1,
+	}
+
+	ctx := context.Background()
+	face, err := source.Next(ctx)
+	if err != nil || face.ImageId != "a" || face.Rect != faceA {
+		t.Fatalf("Unexpected first face %+v - %v", face, err)
+	}
+	source.Retry(face)
+
+	drags := countActions(driver, ActionDrag)
+	retried, err := source.Next(ctx)
+	if err != nil || retried.ImageId != "a" || retried.Rect != faceA || retried.Coords != face.Coords {
+		t.Fatalf("Expected the retried face to be found again at %+v, got %+v - %v", face, retried, err)
+	}
+	if countActions(driver, ActionDrag) != drags {
+		t.Error("Expected the retried face to be sourced from the set it was detected in, without scrolling")
+	}
+
+	next, err := source.Next(ctx)
+	if err != nil || next.ImageId != "b" || next.Rect != faceB {
+		t.Fatalf("Expected the face of the second set after the retried face, got %+v - %v", next, err)
+	}
+	if countActions(driver, ActionDrag) != drags+1 {
+		t.Errorf("Expected a single scroll to the second set, got %d", countActions(driver, ActionDrag)-drags)
+	}
+}
+
+func TestEngineRetryThrottledIsCapped(t *testing.T) {
+	engine := &EnhancementEngine{}
+	face := SourcedFace{ImageId: "42"}
+	for i := 0; i < throttleMaxRetries; i++ {
+		if !engine.retryThrottled(face) {
+			t.Fatalf("Expected refusal %d to be retried", i+1)
+		}
+	}
+	if engine.retryThrottled(face) {
+		t.Error("Expected a refusal beyond the cap to count as a failure")
+	}
+	if !engine.retryThrottled(SourcedFace{ImageId: "43"}) {
+		t.Error("Expected the cap to be counted for each image")
+	}
+}
+
+func TestEngineThrottleBacksOffAndRetries(t *testing.T) {
+	throttledMarker := noiseImage(1, 20, 15)
+	manifest := writeTestAssetPack(t, map[string]image.Image{"try-again-later.png": throttledMarker}, `{"elements": [
+		{"name": "try-again-later", "images": [{"file": "try-again-later.png"}], "state": "throttled", "marker": true}
+	]}`)
+	throttledScreen := noiseImage(11, 200, 150)
+	drawImage(throttledScreen, throttledMarker, image.Pt(90, 65))
+	driver := &MemoryDriver{Width: 200, Height: 150, Screens: []image.Image{noiseImage(10, 200, 150), throttledScreen}}
+	bluestacks := NewBlueStacks(driver)
+	bluestacks.Assets = manifest
+
+	var console bytes.Buffer
+	previous := runLog
+	runLog = &RunLog{RunId: "1638706561", Command: "npc enhance-v2", Level: LevelInfo, console: &console}
+	defer func() { runLog = previous }()
+	logPath := path.Join(t.TempDir(), runLogFile)
+	if err := runLog.OpenFile(logPath); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := NewAdaptiveRateLimiter(10, 10*time.Minute, 8*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &ImportFaceSource{BlueStacks: bluestacks, ImagePaths: []string{"./output/step2/42.jpeg"}, RateLimiter: limiter}
+	status := NewRunStatus("npc enhance-v2")
+	engine := &EnhancementEngine{BlueStacks: bluestacks, Status: status}
+	ctx := context.Background()
+	face, err := source.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failure on any other screen is recovered as usual
+	if engine.throttle(source, face, errors.New("Cannot find Apply text/button")) {
+		t.Fatal("Expected a face that failed on another screen not to be throttled")
+	}
+	if !engine.throttle(source, face, errors.New("Editor did not load")) {
+		t.Fatal("Expected a face that failed on the throttled screen to be throttled")
+	}
+	if err := runLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if limiter.Throttles() != 1 {
+		t.Errorf("Expected the source to back off once, got %d", limiter.Throttles())
+	}
+	if retried, err := source.Next(ctx); err != nil || retried.ImageId != "42" {
+		t.Errorf("Expected the throttled face to be retried, got %+v - %v", retried, err)
+	}
+	if snapshot := status.Snapshot(time.Now()); snapshot.Retried != 1 || snapshot.NextSlot == nil {
+		t.Errorf("Expected the retry and the next slot in the status, got %+v", snapshot)
+	}
+
+	logFile, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var throttled []LogEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(logFile), []byte("\n")) {
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Step == "throttle" {
+			throttled = append(throttled, entry)
+		}
+	}
+	if len(throttled) != 1 || throttled[0].Level != "WARN" || throttled[0].ImageId != "42" || throttled[0].Fields["interval"] != "2m0s" {
+		t.Errorf("Expected a single throttle entry backing off to 2m0s, got %+v", throttled)
+	}
+}
+
+// A FaceSource that fails the test if a face is opened.
+type unopenedFaceSource struct {
+	t *testing.T
+}
+
+func (s unopenedFaceSource) Next(ctx context.Context) (SourcedFace, error) {
+	return SourcedFace{}, ErrNoMoreFaces
+}
+
+func (s unopenedFaceSource) Open(ctx context.Context, face SourcedFace) error {
+	s.t.Fatalf("Expected image %v not to be opened", face.ImageId)
+	return nil
+}
+
+func (s unopenedFaceSource) Retry(face SourcedFace) {}
+
+func (s unopenedFaceSource) Home() ScreenState {
+	return StateGallery
+}
+
+func TestEngineAppliesSafetyPolicyToPlan(t *testing.T) {
+	dir := t.TempDir()
+	var facedataPaths []string
+	for imageId, low := range map[string]int{"7": 12, "42": 21} {
+		facedataPath := path.Join(dir, imageId+".json")
+		err := ioutil.WriteFile(facedataPath, []byte(fmt.Sprintf(`{"FaceDetails": [{"AgeRange": {"Low": %d, "High": 29}}]}`, low)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		facedataPaths = append(facedataPaths, facedataPath)
+	}
+	previous := safetyPolicy
+	defer func() { safetyPolicy = previous }()
+	safetyPolicy = &SafetyPolicy{Age: PolicyAge{Min: enhanceMinAge}, excluded: map[string]bool{"42": true}}
+
+	// Plans are edited by hand, so the plan may want to enhance images the policy rules out
+	plan := &EnhancementPlan{Images: []PlannedImage{
+		{Id: "7", Enhancements: []PlannedEnhancement{{Name: "Glasses", Type: "Glasses"}}},
+		{Id: "42", Enhancements: []PlannedEnhancement{{Name: "Glasses", Type: "Glasses"}}},
+	}}
+	if err := plan.index(); err != nil {
+		t.Fatal(err)
+	}
+	engine := &EnhancementEngine{OutputDir: t.TempDir(), FacedataPaths: facedataPaths, Plan: plan}
+	for _, imageId := range []string{"7", "42", "43"} {
+		if err := engine.enhanceFace(context.Background(), unopenedFaceSource{t}, SourcedFace{ImageId: imageId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reasons := map[string]string{}
+	for _, skipped := range engine.skipped {
+		reasons[skipped.Id] = skipped.Reason
+	}
+	if reasons["7"] != PolicySkipUnderage || reasons["42"] != PolicySkipExcluded || reasons["43"] != PolicySkipNoFacedata {
+		t.Errorf("Expected the planned images to be skipped by the policy, got %v", reasons)
+	}
+}
+
+func TestEngineFaceData(t *testing.T) {
+	dir := t.TempDir()
+	facedataPath := path.Join(dir, "42.json")
+	err := ioutil.WriteFile(facedataPath, []byte(`{"FaceDetails": [{"AgeRange": {"Low": 21, "High": 29}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &EnhancementEngine{FacedataPaths: []string{facedataPath}}
+	facedata, err := engine.faceData("42")
+	if err != nil || len(facedata.FaceDetails) != 1 || *facedata.FaceDetails[0].AgeRange.Low != 21 {
+		t.Errorf("Unexpected face data %+v - %v", facedata, err)
+	}
+	if _, err := engine.faceData("43"); err == nil {
+		t.Error("Expected an image without face analysis data to be an error")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	cli "github.com/spf13/cobra"
+)
+
+// Plans can be edited by hand, so an image can be planned with an enhancement the catalogue does not have.
+const PlanSkipInvalid = "invalid-plan"
+
+var (
+	enhancePlanCmd = &cli.Command{
+		Use:   "plan",
+		Short: "Plan the enhancements of each image from its face analysis data, without touching FaceApp. The plan can be reviewed, adjusted and then carried out with enhance-v2 --plan.",
+		Run:   PlanEnhancements,
+	}
+)
+
+func init() {
+	enhanceCmd.AddCommand(enhancePlanCmd)
+
+	enhancePlanCmd.Flags().String("plan", "", "Path to write the plan to. Defaults to plan.json in the output directory.")
+	enhancePlanCmd.Flags().Int64("seed", 0, "Seed of the plan. Each image is planned with a seed derived from it and the image id, so the same seed always produces the same plan. Defaults to the current timestamp.")
+}
+
+// EnhancementPlan is the enhancements decided for each image ahead of a run.
+type EnhancementPlan struct {
+	Seed      int64          `json:"seed"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Images    []PlannedImage `json:"images"`
+
+	byId map[string]*PlannedImage
+}
+
+// PlannedImage is the enhancements of one image, and the seed they were chosen with.
+// Images that should not be enhanced have the reason code in Skip.
+type PlannedImage struct {
+	Id           string               `json:"id"`
+	Seed         int64                `json:"seed"`
+	Skip         string               `json:"skip,omitempty"`
+	Enhancements []PlannedEnhancement `json:"enhancements"`
+}
+
+type PlannedEnhancement struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func PlanEnhancements(cmd *cli.Command, args []string) {
+	outputDir, _ := cmd.Flags().GetString("output")
+	sourceDir, _ := cmd.Flags().GetString("source")
+	facedataDir, _ := cmd.Flags().GetString("facedata")
+	planPath, _ := cmd.Flags().GetString("plan")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	if seed == 0 {
+		seed = currentTs
+	}
+	if planPath == "" {
+		planPath = path.Join(outputDir, "plan.json")
+	}
+
+	manifest, err := LoadAssetManifest(faceappAssetsDir)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load asset manifest - %v", err.Error())
+	}
+	useEnhancementCatalogueFlag(cmd, manifest)
+	useSafetyPolicyFlag(cmd)
+
+	imagePaths, err := filepath.Glob(path.Join(sourceDir, "/*.jpeg"))
+	if err != nil {
+		log.Fatal("ERROR: ", err.Error())
+	}
+	facedataPaths, err := filepath.Glob(path.Join(facedataDir, "/*.json"))
+	if err != nil {
+		log.Fatal("ERROR: ", err.Error())
+	}
+	var imageIds []string
+	for _, imagePath := range imagePaths {
+		imageIds = append(imageIds, getFileName(imagePath))
+	}
+
+	// Quotas count the images enhanced in previous runs
+	indexPaths, err := runIndexPaths(outputDir, "")
+	if err != nil {
+		log.Fatal("ERROR: ", err.Error())
+	}
+	quotas, err := LoadQuotaTracker(indexPaths)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot count enhancements of previous runs - %v", err.Error())
+	}
+
+	plan := NewEnhancementPlan(seed, imageIds, facedataPaths, quotas)
+	err = plan.Save(planPath)
+	if err != nil {
+		log.Fatal("ERROR: ", err.Error())
+	}
+	for _, unmet := range quotas.Unmet() {
+		log.Printf("WARN: %v\n", unmet)
+	}
+	skipped := 0
+	for _, planned := range plan.Images {
+		if planned.Skip != "" {
+			skipped++
+		}
+	}
+	log.Printf("Planned enhancements of %d images with seed %d, skipping %d - written to %v\n", len(plan.Images), seed, skipped, planPath)
+}
+
+// Plan the enhancements of each image from its face analysis data.
+// With quotas, every image is expected before any is planned, and the planned enhancements are counted as they are chosen.
+func NewEnhancementPlan(seed int64, imageIds []string, facedataPaths []string, quotas *QuotaTracker) *EnhancementPlan {
+	plan := &EnhancementPlan{
+		Seed:      seed,
+		CreatedAt: time.Now(),
+	}
+	faces := map[string]FaceData{}
+	for _, imageId := range imageIds {
+		facedata, err := loadFaceData(facedataPaths, imageId)
+		if err != nil {
+			log.Printf("WARN: Cannot plan image %v - %v\n", imageId, err.Error())
+			continue
+		}
+		faces[imageId] = facedata
+		if safetyPolicy.Check(imageId, facedata) == "" {
+			quotas.Expect(imageId, facedata.FaceDetails[0])
+		}
+	}
+	for _, imageId := range imageIds {
+		facedata, found := faces[imageId]
+		if !found {
+			plan.Images = append(plan.Images, PlannedImage{Id: imageId, Seed: imageSeed(seed, imageId), Skip: PolicySkipNoFacedata})
+			continue
+		}
+		planned := planImage(imageSeed(seed, imageId), imageId, facedata, quotas)
+		if planned.Skip == "" {
+			quotas.Record(planned.indexed())
+		}
+		plan.Images = append(plan.Images, planned)
+	}
+	plan.index()
+	return plan
+}
+
+// Each image is planned with its own seed, so that adding, removing or re-planning an image does not change the plan of any other -- unless a quota links them.
+func imageSeed(seed int64, imageId string) int64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d/%s", seed, imageId)
+	return int64(hash.Sum64())
+}
+
+// Plan the enhancements of an image with its seed. The image is no longer expected by the quotas once planned, but its enhancements are not counted.
+// Images the safety policy rules out are planned to be skipped, with the reason code.
+func planImage(seed int64, imageId string, facedata FaceData, quotas *QuotaTracker) PlannedImage {
+	planned := PlannedImage{
+		Id:           imageId,
+		Seed:         seed,
+		Enhancements: []PlannedEnhancement{},
+	}
+	planned.Skip = safetyPolicy.Check(imageId, facedata)
+	if planned.Skip != "" {
+		return planned
+	}
+	chosen := chooseEnhancements(facedata.FaceDetails[0], rand.New(rand.NewSource(seed)), quotas)
+	quotas.Pass(imageId)
+	for _, selected := range chosen {
+		planned.Enhancements = append(planned.Enhancements, PlannedEnhancement{
+			Name: selected.Enhancement.Name,
+			Type: selected.Type.Name,
+		})
+	}
+	return planned
+}
+
+// Load a plan, checking that each of its enhancements is in the enhancement catalogue.
+func LoadEnhancementPlan(planPath string) (*EnhancementPlan, error) {
+	file, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		return nil, err
+	}
+	plan := &EnhancementPlan{}
+	err = json.Unmarshal(file, plan)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, planPath)
+	}
+	err = plan.index()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, planPath)
+	}
+	for _, planned := range plan.Images {
+		if _, err := planned.Selected(); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, planPath)
+		}
+	}
+	return plan, nil
+}
+
+func (p *EnhancementPlan) index() error {
+	p.byId = map[string]*PlannedImage{}
+	for i := range p.Images {
+		if p.byId[p.Images[i].Id] != nil {
+			return fmt.Errorf("Image %v is planned more than once", p.Images[i].Id)
+		}
+		p.byId[p.Images[i].Id] = &p.Images[i]
+	}
+	return nil
+}
+
+func (p *EnhancementPlan) Image(imageId string) (PlannedImage, bool) {
+	planned, found := p.byId[imageId]
+	if !found {
+		return PlannedImage{}, false
+	}
+	return *planned, true
+}
+
+func (p *EnhancementPlan) Save(planPath string) error {
+	planJson, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(planPath), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(planPath, planJson, 0644)
+}
+
+// The index format of the enhancements of a planned image.
+func (p PlannedImage) indexed() IndexedImage {
+	indexed := IndexedImage{Id: p.Id, Enhancements: []map[string]string{}}
+	for _, planned := range p.Enhancements {
+		indexed.Enhancements = append(indexed.Enhancements, map[string]string{
+			"name": planned.Name,
+			"type": planned.Type,
+		})
+	}
+	return indexed
+}
+
+// The catalogue enhancements and types of a planned image, in the order they are applied.
+func (p PlannedImage) Selected() ([]SelectedEnhancement, error) {
+	var selected []SelectedEnhancement
+	for _, planned := range p.Enhancements {
+		enhancement, eType, err := findEnhancement(planned.Name, planned.Type)
+		if err != nil {
+			return nil, fmt.Errorf("Image %v - %v", p.Id, err)
+		}
+		selected = append(selected, SelectedEnhancement{Enhancement: enhancement, Type: eType})
+	}
+	return selected, nil
+}
+
+// Fetch the face analysis data of an image from the paths of the facedata files, with every face detected in it. Images without any faces detected are an error.
+func loadFaceData(facedataPaths []string, imageId string) (FaceData, error) {
+	facedata := FaceData{}
+	for _, facedataPath := range facedataPaths {
+		if getFileName(facedataPath) == imageId {
+			// Read the file and unmarshal the data
+			file, err := ioutil.ReadFile(facedataPath)
+			if err == nil {
+				err = json.Unmarshal(file, &facedata)
+			}
+			if err != nil {
+				return FaceData{}, fmt.Errorf("%v: %s", err, facedataPath)
+			}
+			break
+		}
+	}
+	if len(facedata.FaceDetails) == 0 {
+		return FaceData{}, fmt.Errorf("No face analysis data for image %v", imageId)
+	}
+	return facedata, nil
+}
+
+func findEnhancement(name, typeName string) (Enhancement, EnhancementType, error) {
+	for _, enhancement := range enhancements {
+		if enhancement.Name != name {
+			continue
+		}
+		for _, eType := range enhancement.Types {
+			if eType.Name == typeName {
+				return enhancement, eType, nil
+			}
+		}
+		return Enhancement{}, EnhancementType{}, fmt.Errorf("Enhancement %v has no type %v", name, typeName)
+	}
+	return Enhancement{}, EnhancementType{}, errors.New("Unknown enhancement " + name)
+}
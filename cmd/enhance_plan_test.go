@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func writeTestFacedata(t *testing.T, dir string, facedata map[string]string) []string {
+	var facedataPaths []string
+	for imageId, contents := range facedata {
+		facedataPath := path.Join(dir, imageId+".json")
+		err := ioutil.WriteFile(facedataPath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		facedataPaths = append(facedataPaths, facedataPath)
+	}
+	return facedataPaths
+}
+
+func TestEnhancementPlanIsDeterministic(t *testing.T) {
+	facedataPaths := writeTestFacedata(t, t.TempDir(), map[string]string{
+		"1": `{"FaceDetails": [{"AgeRange": {"Low": 21}, "Gender": {"Value": "Male"}}]}`,
+		"2": `{"FaceDetails": [{"AgeRange": {"Low": 30}, "Gender": {"Value": "Male"}, "Beard": {"Value": true}}]}`,
+		"3": `{"FaceDetails": [{"AgeRange": {"Low": 12}, "Gender": {"Value": "Male"}}]}`,
+	})
+	imageIds := []string{"1", "2", "3", "4"}
+	plan := NewEnhancementPlan(7, imageIds, facedataPaths, nil)
+	again := NewEnhancementPlan(7, imageIds, facedataPaths, nil)
+	if !reflect.DeepEqual(plan.Images, again.Images) {
+		t.Fatalf("Expected the same seed to plan the same enhancements, got %+v and %+v", plan.Images, again.Images)
+	}
+
+	// Planning fewer images does not change the plan of the others.
+	fewer := NewEnhancementPlan(7, []string{"2"}, facedataPaths, nil)
+	if planned, _ := plan.Image("2"); !reflect.DeepEqual(fewer.Images[0], planned) {
+		t.Errorf("Expected image 2 to be planned the same on its own, got %+v and %+v", fewer.Images[0], planned)
+	}
+
+	if planned, _ := plan.Image("2"); planned.Skip != "" || len(planned.Enhancements) != 1 || planned.Enhancements[0].Name != "Beards" {
+		t.Errorf("Expected a beard for image 2, got %+v", planned)
+	}
+	if planned, _ := plan.Image("3"); planned.Skip != PolicySkipUnderage {
+		t.Errorf("Expected underage image 3 to be skipped, got %+v", planned)
+	}
+	if planned, _ := plan.Image("4"); planned.Skip != PolicySkipNoFacedata {
+		t.Errorf("Expected image 4 without facedata to be skipped, got %+v", planned)
+	}
+}
+
+func TestLoadEnhancementPlan(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		contents string
+		valid    bool
+	}{
+		{"valid", `{"seed": 1, "images": [{"id": "1", "enhancements": [{"name": "Beards", "type": "Goatee"}]}, {"id": "2", "skip": "underage"}]}`, true},
+		{"unknown-enhancement", `{"images": [{"id": "1", "enhancements": [{"name": "Tattoos", "type": "Goatee"}]}]}`, false},
+		{"unknown-type", `{"images": [{"id": "1", "enhancements": [{"name": "Beards", "type": "Handlebar"}]}]}`, false},
+		{"duplicate-image", `{"images": [{"id": "1"}, {"id": "1"}]}`, false},
+	} {
+		planPath := path.Join(dir, tc.name+".json")
+		err := ioutil.WriteFile(planPath, []byte(tc.contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := LoadEnhancementPlan(planPath)
+		if tc.valid != (err == nil) {
+			t.Errorf("%v: unexpected error %v", tc.name, err)
+			continue
+		}
+		if tc.valid {
+			if paths := plannedImagePaths(plan, []string{"./1.jpeg", "./2.jpeg", "./3.jpeg"}); !reflect.DeepEqual(paths, []string{"./1.jpeg"}) {
+				t.Errorf("Expected only the planned image to be imported, got %v", paths)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	failedImagesFile = "failed.json"
+	// Diagnostic screenshots of failed images are written to this directory of the run's output directory.
+	failureScreenshotsDir = "failures"
+	// The number of times a failed image is retried by default before it is quarantined.
+	defaultMaxRetries = 2
+)
+
+// FailedImage is an image that failed every attempt to enhance it, and was set aside so that the run could carry on.
+type FailedImage struct {
+	Id          string   `json:"id"`
+	Path        string   `json:"path,omitempty"`
+	Reason      string   `json:"reason"`
+	Attempts    int      `json:"attempts"`
+	Screenshots []string `json:"screenshots,omitempty"`
+}
+
+// RecoveryPolicy decides what becomes of an image that failed part way through enhancement -- it is retried up to MaxRetries times, then quarantined to failed.json in the run's output directory.
+// Images quarantined by an earlier attempt at the run are loaded with it, and released again if they are enhanced when the run is resumed.
+type RecoveryPolicy struct {
+	MaxRetries  int
+	Path        string
+	Quarantined []FailedImage
+
+	attempts    map[string]int
+	screenshots map[string][]string
+	recovered   []string
+}
+
+func NewRecoveryPolicy(outputDir string, maxRetries int) (*RecoveryPolicy, error) {
+	p := &RecoveryPolicy{
+		MaxRetries:  maxRetries,
+		Path:        path.Join(outputDir, failedImagesFile),
+		attempts:    map[string]int{},
+		screenshots: map[string][]string{},
+	}
+	file, err := ioutil.ReadFile(p.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(file, &p.Quarantined)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, p.Path)
+	}
+	return p, nil
+}
+
+// Record a failed attempt at an image, along with a screenshot of the screen it failed on.
+// Returns true when the image should be retried. Otherwise it has been quarantined.
+func (p *RecoveryPolicy) Fail(face SourcedFace, reason error, screenshotPath string) (bool, error) {
+	p.attempts[face.ImageId]++
+	if screenshotPath != "" {
+		p.screenshots[face.ImageId] = append(p.screenshots[face.ImageId], screenshotPath)
+	}
+	if p.attempts[face.ImageId] <= p.MaxRetries {
+		return true, nil
+	}
+	p.release(face.ImageId)
+	p.Quarantined = append(p.Quarantined, FailedImage{
+		Id:          face.ImageId,
+		Path:        face.Path,
+		Reason:      reason.Error(),
+		Attempts:    p.attempts[face.ImageId],
+		Screenshots: p.screenshots[face.ImageId],
+	})
+	return false, p.save()
+}
+
+// Record that an image was done with, after any failed attempts at it.
+func (p *RecoveryPolicy) Succeed(imageId string) error {
+	if p.attempts[imageId] > 0 {
+		p.recovered = append(p.recovered, imageId)
+		delete(p.attempts, imageId)
+		delete(p.screenshots, imageId)
+	}
+	if p.release(imageId) {
+		return p.save()
+	}
+	return nil
+}
+
+// The number of failed attempts at an image so far.
+func (p *RecoveryPolicy) Attempts(imageId string) int {
+	return p.attempts[imageId]
+}
+
+// The images that failed at least once, then were done with on a retry.
+func (p *RecoveryPolicy) Recovered() []string {
+	return p.recovered
+}
+
+// Remove an image from quarantine. Returns false if it was not quarantined.
+func (p *RecoveryPolicy) release(imageId string) bool {
+	for i, failed := range p.Quarantined {
+		if failed.Id == imageId {
+			p.Quarantined = append(p.Quarantined[:i], p.Quarantined[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RecoveryPolicy) save() error {
+	failedJson, err := json.MarshalIndent(p.Quarantined, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.Path, failedJson, 0644)
+}
+
+// Describe the images recovered and quarantined, for the end of a run.
+func (p *RecoveryPolicy) Summary() []string {
+	summary := []string{fmt.Sprintf("%d images recovered after a failure, %d quarantined to %v", len(p.recovered), len(p.Quarantined), p.Path)}
+	for _, imageId := range p.recovered {
+		summary = append(summary, fmt.Sprintf("Recovered image %v", imageId))
+	}
+	for _, failed := range p.Quarantined {
+		summary = append(summary, fmt.Sprintf("Quarantined image %v after %d attempts - %v", failed.Id, failed.Attempts, failed.Reason))
+	}
+	return summary
+}
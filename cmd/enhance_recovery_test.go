@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestRecoveryPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policy, err := NewRecoveryPolicy(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := SourcedFace{ImageId: "42", Path: "./output/step2/42.jpeg"}
+	for attempt := 1; attempt <= 2; attempt++ {
+		retry, err := policy.Fail(face, errors.New("Cannot find Apply text/button"), "failures/42.jpeg")
+		if err != nil || !retry {
+			t.Fatalf("Expected attempt %d to be retried - %v", attempt, err)
+		}
+	}
+	retry, err := policy.Fail(face, errors.New("Cannot find Save text/button"), "")
+	if err != nil || retry {
+		t.Fatalf("Expected the image to be quarantined once it has no retries left - %v", err)
+	}
+
+	file, err := ioutil.ReadFile(path.Join(dir, failedImagesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []FailedImage
+	if err := json.Unmarshal(file, &failed); err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Id != "42" || failed[0].Attempts != 3 || failed[0].Reason != "Cannot find Save text/button" || len(failed[0].Screenshots) != 2 {
+		t.Fatalf("Unexpected failed images %+v", failed)
+	}
+
+	if err := policy.Succeed("43"); err != nil || len(policy.Recovered()) != 0 {
+		t.Errorf("Expected an image without failures not to be recovered, got %v - %v", policy.Recovered(), err)
+	}
+	if _, err := policy.Fail(SourcedFace{ImageId: "43"}, errors.New("Failed to Save"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Succeed("43"); err != nil || len(policy.Recovered()) != 1 || policy.Recovered()[0] != "43" {
+		t.Errorf("Expected image 43 to be recovered, got %v - %v", policy.Recovered(), err)
+	}
+}
+
+func TestRecoveryPolicyResume(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(dir, failedImagesFile), []byte(`[{"id": "42", "reason": "Failed to Save", "attempts": 3}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewRecoveryPolicy(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Quarantined) != 1 {
+		t.Fatalf("Expected the quarantined image of the resumed run, got %+v", policy.Quarantined)
+	}
+	if err := policy.Succeed("42"); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := NewRecoveryPolicy(dir, 2)
+	if err != nil || len(resumed.Quarantined) != 0 {
+		t.Errorf("Expected an image enhanced on resume to be released, got %+v - %v", resumed.Quarantined, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// EnhancementQuota limits how many images of the whole collection get an enhancement or type, counted across runs.
+// Exactly is a target -- once the images left to choose for are only just enough to meet it, the enhancement or type is forced onto each eligible image.
+// MaxShare is a fraction of the images in the collection.
+type EnhancementQuota struct {
+	Exactly  *int     `json:"exactly,omitempty"`
+	Max      *int     `json:"max,omitempty"`
+	MaxShare *float64 `json:"maxShare,omitempty"`
+}
+
+func (q *EnhancementQuota) validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Exactly == nil && q.Max == nil && q.MaxShare == nil {
+		return errors.New("Quota has no limit")
+	}
+	if q.Exactly != nil && (q.Max != nil || q.MaxShare != nil) {
+		return errors.New("Quota of exactly a number of images cannot also have a max")
+	}
+	if (q.Exactly != nil && *q.Exactly < 0) || (q.Max != nil && *q.Max < 0) {
+		return errors.New("Quota is negative")
+	}
+	if q.MaxShare != nil && (*q.MaxShare < 0 || *q.MaxShare > 1) {
+		return errors.New("Quota has a max share outside of 0 to 1")
+	}
+	return nil
+}
+
+// QuotaTracker counts the enhancements and types given to each image of the collection, so that quotas hold across runs.
+// Images that are still to be chosen for are expected up front, so that exact quotas can be met and shares taken of the whole collection.
+// A nil tracker has no quotas.
+type QuotaTracker struct {
+	counts    map[string]int
+	images    map[string][]string // Quota keys of each image counted
+	expected  map[string][]string // Quota keys each expected image is eligible for
+	remaining map[string]int      // Number of expected images eligible for each quota key
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		counts:    map[string]int{},
+		images:    map[string][]string{},
+		expected:  map[string][]string{},
+		remaining: map[string]int{},
+	}
+}
+
+// Count the enhancements of the images in index.json files of previous runs. Files that do not exist are skipped.
+// Files are counted in path order, so when an image was enhanced in more than one run, the run with the latest timestamp counts.
+func LoadQuotaTracker(indexPaths []string) (*QuotaTracker, error) {
+	tracker := NewQuotaTracker()
+	sort.Strings(indexPaths)
+	for _, indexPath := range indexPaths {
+		file, err := ioutil.ReadFile(indexPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var indexedImages []IndexedImage
+		err = json.Unmarshal(file, &indexedImages)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, indexPath)
+		}
+		for _, indexedImage := range indexedImages {
+			tracker.Record(indexedImage)
+		}
+	}
+	return tracker, nil
+}
+
+// The index.json files of the runs in an output directory, along with the run being resumed wherever it is.
+func runIndexPaths(outputParentDir, resumeDir string) ([]string, error) {
+	indexPaths, err := filepath.Glob(path.Join(outputParentDir, "*", "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if resumeDir != "" {
+		resumeIndexPath := path.Join(resumeDir, "index.json")
+		for _, indexPath := range indexPaths {
+			if filepath.Clean(indexPath) == filepath.Clean(resumeIndexPath) {
+				return indexPaths, nil
+			}
+		}
+		indexPaths = append(indexPaths, resumeIndexPath)
+	}
+	return indexPaths, nil
+}
+
+// Quotas of an enhancement are keyed by its name, and quotas of a type by the enhancement and type names.
+func quotaKey(enhancementName, typeName string) string {
+	if typeName == "" {
+		return enhancementName
+	}
+	return enhancementName + "/" + typeName
+}
+
+// Count the enhancements of an image, replacing any it was counted with before. Unverified enhancements are not counted.
+func (t *QuotaTracker) Record(indexedImage IndexedImage) {
+	if t == nil {
+		return
+	}
+	for _, key := range t.images[indexedImage.Id] {
+		t.counts[key]--
+	}
+	var keys []string
+	for _, applied := range indexedImage.Enhancements {
+		if applied["unverified"] == "true" {
+			continue
+		}
+		keys = append(keys, quotaKey(applied["name"], ""), quotaKey(applied["name"], applied["type"]))
+	}
+	t.images[indexedImage.Id] = keys
+	for _, key := range keys {
+		t.counts[key]++
+	}
+}
+
+// Expect an image to be chosen for later in the run.
+func (t *QuotaTracker) Expect(imageId string, faceDetails types.FaceDetail) {
+	if t == nil {
+		return
+	}
+	t.Pass(imageId)
+	var keys []string
+	for _, enhancement := range enhancements {
+		if !enhancement.Evaluate(faceDetails).Eligible {
+			continue
+		}
+		keys = append(keys, quotaKey(enhancement.Name, ""))
+		for _, eType := range enhancement.Types {
+			if eType.Evaluate(faceDetails).Eligible {
+				keys = append(keys, quotaKey(enhancement.Name, eType.Name))
+			}
+		}
+	}
+	t.expected[imageId] = keys
+	for _, key := range keys {
+		t.remaining[key]++
+	}
+}
+
+// Stop expecting an image, once its enhancements have been chosen.
+func (t *QuotaTracker) Pass(imageId string) {
+	if t == nil {
+		return
+	}
+	for _, key := range t.expected[imageId] {
+		t.remaining[key]--
+	}
+	delete(t.expected, imageId)
+}
+
+func (t *QuotaTracker) Count(key string) int {
+	if t == nil {
+		return 0
+	}
+	return t.counts[key]
+}
+
+// The number of images in the collection -- those counted and those expected.
+func (t *QuotaTracker) CollectionSize() int {
+	size := len(t.images)
+	for imageId := range t.expected {
+		if _, counted := t.images[imageId]; !counted {
+			size++
+		}
+	}
+	return size
+}
+
+// The most images a quota allows.
+func (t *QuotaTracker) maxCount(quota *EnhancementQuota) (int, bool) {
+	max, limited := 0, false
+	if quota.Exactly != nil {
+		max, limited = *quota.Exactly, true
+	}
+	if quota.Max != nil {
+		max, limited = *quota.Max, true
+	}
+	if quota.MaxShare != nil {
+		shareMax := int(math.Floor(*quota.MaxShare * float64(t.CollectionSize())))
+		if !limited || shareMax < max {
+			max, limited = shareMax, true
+		}
+	}
+	return max, limited
+}
+
+// Whether a quota allows no more images.
+func (t *QuotaTracker) Full(quota *EnhancementQuota, key string) bool {
+	if t == nil || quota == nil {
+		return false
+	}
+	max, limited := t.maxCount(quota)
+	return limited && t.counts[key] >= max
+}
+
+// Whether an exact quota can only be met by every eligible image still expected, including the one being chosen for.
+func (t *QuotaTracker) Due(quota *EnhancementQuota, key string) bool {
+	if t == nil || quota == nil || quota.Exactly == nil || t.remaining[key] <= 0 {
+		return false
+	}
+	return *quota.Exactly-t.counts[key] >= t.remaining[key]
+}
+
+// Describe the exact quotas of the catalogue that have not been met.
+func (t *QuotaTracker) Unmet() []string {
+	if t == nil {
+		return nil
+	}
+	var unmet []string
+	check := func(quota *EnhancementQuota, key string) {
+		if quota != nil && quota.Exactly != nil && t.counts[key] != *quota.Exactly {
+			unmet = append(unmet, fmt.Sprintf("Quota of exactly %d %v is not met - %d images have it", *quota.Exactly, key, t.counts[key]))
+		}
+	}
+	for _, enhancement := range enhancements {
+		check(enhancement.Quota, quotaKey(enhancement.Name, ""))
+		for _, eType := range enhancement.Types {
+			check(eType.Quota, quotaKey(enhancement.Name, eType.Name))
+		}
+	}
+	return unmet
+}
+
+// The index format of the enhancements chosen for an image.
+func selectedEnhancementsIndex(selected []SelectedEnhancement) []map[string]string {
+	indexed := []map[string]string{}
+	for _, s := range selected {
+		indexed = append(indexed, map[string]string{
+			"name": s.Enhancement.Name,
+			"type": s.Type.Name,
+		})
+	}
+	return indexed
+}
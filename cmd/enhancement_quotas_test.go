@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+func TestChooseEnhancementTypeIsWeighted(t *testing.T) {
+	types3to1 := []EnhancementType{{Name: "Full beard", Probability: 0.3}, {Name: "Lion", Probability: 0.1}}
+	for _, order := range [][]EnhancementType{types3to1, {types3to1[1], types3to1[0]}} {
+		enhancement := Enhancement{Name: "Beards", Types: order}
+		r := rand.New(rand.NewSource(1))
+		lions := 0
+		draws := 10000
+		for i := 0; i < draws; i++ {
+			if eType, _ := chooseEnhancementType(enhancement, types.FaceDetail{}, r, nil); eType.Name == "Lion" {
+				lions++
+			}
+		}
+		if share := float64(lions) / float64(draws); math.Abs(share-0.25) > 0.02 {
+			t.Errorf("Expected Lion a quarter of the time whatever the order, got %v", share)
+		}
+	}
+}
+
+// Swap in a catalogue for the length of a test.
+func useTestEnhancements(t *testing.T, catalogue []Enhancement) {
+	previous := enhancements
+	enhancements = catalogue
+	t.Cleanup(func() { enhancements = previous })
+}
+
+func testMaleFaces(n int) (map[string]types.FaceDetail, []string) {
+	low := int32(30)
+	faces := map[string]types.FaceDetail{}
+	var imageIds []string
+	for i := 0; i < n; i++ {
+		imageId := fmt.Sprint(i)
+		faces[imageId] = types.FaceDetail{AgeRange: &types.AgeRange{Low: &low}, Gender: &types.Gender{Value: types.GenderTypeMale}}
+		imageIds = append(imageIds, imageId)
+	}
+	return faces, imageIds
+}
+
+// Choose for each face in order, as a plan does.
+func chooseWithQuotas(faces map[string]types.FaceDetail, imageIds []string, quotas *QuotaTracker, seed int64) {
+	for _, imageId := range imageIds {
+		quotas.Expect(imageId, faces[imageId])
+	}
+	r := rand.New(rand.NewSource(seed))
+	for _, imageId := range imageIds {
+		selected := chooseEnhancements(faces[imageId], r, quotas)
+		quotas.Pass(imageId)
+		quotas.Record(IndexedImage{Id: imageId, Enhancements: selectedEnhancementsIndex(selected)})
+	}
+}
+
+func TestExactQuotaIsMet(t *testing.T) {
+	three := 3
+	useTestEnhancements(t, []Enhancement{{Name: "Beards", Probability: 0.05, GenderRequirement: "Male", Types: []EnhancementType{
+		{Name: "Full beard", Probability: 0.5},
+		{Name: "Lion", Probability: 0.5, Quota: &EnhancementQuota{Exactly: &three}},
+	}}})
+	faces, imageIds := testMaleFaces(20)
+
+	// A previous run already gave one image a Lion beard
+	indexPath := path.Join(t.TempDir(), "index.json")
+	err := ioutil.WriteFile(indexPath, []byte(`[{"id": "old", "enhancements": [{"name": "Beards", "type": "Lion"}]}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		quotas, err := LoadQuotaTracker([]string{indexPath, path.Join(t.TempDir(), "missing.json")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chooseWithQuotas(faces, imageIds, quotas, seed)
+		if count := quotas.Count(quotaKey("Beards", "Lion")); count != 3 {
+			t.Errorf("Seed %d: expected exactly 3 Lion beards, got %d", seed, count)
+		}
+		if unmet := quotas.Unmet(); len(unmet) != 0 {
+			t.Errorf("Seed %d: unexpected unmet quotas %v", seed, unmet)
+		}
+	}
+}
+
+func TestMaxShareQuota(t *testing.T) {
+	share := 0.2
+	useTestEnhancements(t, []Enhancement{{Name: "Beards", Probability: 1, Quota: &EnhancementQuota{MaxShare: &share}, Types: []EnhancementType{
+		{Name: "Full beard", Probability: 1},
+	}}})
+	faces, imageIds := testMaleFaces(10)
+	quotas := NewQuotaTracker()
+	chooseWithQuotas(faces, imageIds, quotas, 1)
+	if count := quotas.Count(quotaKey("Beards", "")); count != 2 {
+		t.Errorf("Expected 20%% of 10 images to get a beard, got %d", count)
+	}
+}
+
+func TestQuotaTrackerRecordReplacesImage(t *testing.T) {
+	quotas := NewQuotaTracker()
+	beard := []map[string]string{{"name": "Beards", "type": "Lion"}}
+	quotas.Record(IndexedImage{Id: "1", Enhancements: beard})
+	quotas.Record(IndexedImage{Id: "1", Enhancements: beard})
+	quotas.Record(IndexedImage{Id: "2", Enhancements: beard})
+	quotas.Record(IndexedImage{Id: "2"})
+	if count := quotas.Count(quotaKey("Beards", "Lion")); count != 1 {
+		t.Errorf("Expected an image to be counted once, got %d", count)
+	}
+	if size := quotas.CollectionSize(); size != 2 {
+		t.Errorf("Expected a collection of 2 images, got %d", size)
+	}
+}
+
+func TestQuotaTrackerSkipsUnverified(t *testing.T) {
+	quotas := NewQuotaTracker()
+	quotas.Record(IndexedImage{Id: "1", Enhancements: []map[string]string{
+		{"name": "Beards", "type": "Lion", "unverified": "true"},
+		{"name": "Sizes", "type": "Big Face"},
+	}})
+	if quotas.Count(quotaKey("Beards", "")) != 0 || quotas.Count(quotaKey("Sizes", "Big Face")) != 1 {
+		t.Errorf("Expected only verified enhancements to be counted")
+	}
+}
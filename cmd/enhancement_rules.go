@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// Effects of an enhancement rule whose conditions all hold for a face.
+const (
+	// The enhancement or type is only eligible for faces that meet the conditions.
+	RuleRequire = "require"
+	// The enhancement is applied regardless of its probability, or the type is chosen over the others.
+	RuleForce = "force"
+	// The enhancement or type is never applied.
+	RuleForbid = "forbid"
+	// The probability is multiplied by the rule's weight.
+	RuleWeight = "weight"
+)
+
+// Emotions reported by Rekognition. Conditions on Emotions.<NAME> test the confidence of the emotion, which is 0 when it is not reported.
+var faceEmotionNames = []string{"HAPPY", "SAD", "ANGRY", "CONFUSED", "DISGUSTED", "SURPRISED", "CALM", "FEAR", "UNKNOWN"}
+
+// EnhancementRule changes whether and how likely an enhancement or type is chosen for faces that meet all of its conditions.
+type EnhancementRule struct {
+	When   []EnhancementCondition `json:"when"`
+	Effect string                 `json:"effect"`
+	Weight float64                `json:"weight,omitempty"`
+}
+
+// EnhancementCondition tests one field of a face's Rekognition FaceDetail, such as AgeRange.Low, Gender, Gender.Confidence, Beard, Smile.Confidence, Emotions.HAPPY or Pose.Yaw.
+// Boolean fields are tested with Is, the gender with Equals, and numbers with Min and Max, which are inclusive.
+// A condition never holds for a field the face analysis data does not have.
+type EnhancementCondition struct {
+	Field  string   `json:"field"`
+	Is     *bool    `json:"is,omitempty"`
+	Equals string   `json:"equals,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+}
+
+// RuleOutcome is the combined effect of a set of rules on a face.
+type RuleOutcome struct {
+	Eligible bool
+	Forced   bool
+	Weight   float64
+}
+
+type faceFieldKind int
+
+const (
+	faceFieldBool faceFieldKind = iota
+	faceFieldString
+	faceFieldNumber
+)
+
+// A field of a FaceDetail, or false when the face analysis data does not have it.
+type faceField struct {
+	Kind faceFieldKind
+	Get  func(f types.FaceDetail) (interface{}, bool)
+}
+
+func float32Field(v *float32) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	return float64(*v), true
+}
+
+func int32Field(v *int32) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	return float64(*v), true
+}
+
+var faceFields = map[string]faceField{
+	"Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) { return float32Field(f.Confidence) }},
+	"AgeRange.Low": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.AgeRange == nil {
+			return nil, false
+		}
+		return int32Field(f.AgeRange.Low)
+	}},
+	"AgeRange.High": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.AgeRange == nil {
+			return nil, false
+		}
+		return int32Field(f.AgeRange.High)
+	}},
+	"Gender": {faceFieldString, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Gender == nil {
+			return nil, false
+		}
+		return string(f.Gender.Value), true
+	}},
+	"Gender.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Gender == nil {
+			return nil, false
+		}
+		return float32Field(f.Gender.Confidence)
+	}},
+	"Beard": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Beard == nil {
+			return nil, false
+		}
+		return f.Beard.Value, true
+	}},
+	"Beard.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Beard == nil {
+			return nil, false
+		}
+		return float32Field(f.Beard.Confidence)
+	}},
+	"Mustache": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Mustache == nil {
+			return nil, false
+		}
+		return f.Mustache.Value, true
+	}},
+	"Mustache.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Mustache == nil {
+			return nil, false
+		}
+		return float32Field(f.Mustache.Confidence)
+	}},
+	"Eyeglasses": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Eyeglasses == nil {
+			return nil, false
+		}
+		return f.Eyeglasses.Value, true
+	}},
+	"Eyeglasses.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Eyeglasses == nil {
+			return nil, false
+		}
+		return float32Field(f.Eyeglasses.Confidence)
+	}},
+	"Sunglasses": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Sunglasses == nil {
+			return nil, false
+		}
+		return f.Sunglasses.Value, true
+	}},
+	"Sunglasses.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Sunglasses == nil {
+			return nil, false
+		}
+		return float32Field(f.Sunglasses.Confidence)
+	}},
+	"Smile": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Smile == nil {
+			return nil, false
+		}
+		return f.Smile.Value, true
+	}},
+	"Smile.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Smile == nil {
+			return nil, false
+		}
+		return float32Field(f.Smile.Confidence)
+	}},
+	"MouthOpen": {faceFieldBool, func(f types.FaceDetail) (interface{}, bool) {
+		if f.MouthOpen == nil {
+			return nil, false
+		}
+		return f.MouthOpen.Value, true
+	}},
+	"MouthOpen.Confidence": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.MouthOpen == nil {
+			return nil, false
+		}
+		return float32Field(f.MouthOpen.Confidence)
+	}},
+	"Pose.Pitch": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Pose == nil {
+			return nil, false
+		}
+		return float32Field(f.Pose.Pitch)
+	}},
+	"Pose.Roll": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Pose == nil {
+			return nil, false
+		}
+		return float32Field(f.Pose.Roll)
+	}},
+	"Pose.Yaw": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Pose == nil {
+			return nil, false
+		}
+		return float32Field(f.Pose.Yaw)
+	}},
+	"Quality.Brightness": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Quality == nil {
+			return nil, false
+		}
+		return float32Field(f.Quality.Brightness)
+	}},
+	"Quality.Sharpness": {faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+		if f.Quality == nil {
+			return nil, false
+		}
+		return float32Field(f.Quality.Sharpness)
+	}},
+}
+
+func lookupFaceField(name string) (faceField, error) {
+	if field, found := faceFields[name]; found {
+		return field, nil
+	}
+	if strings.HasPrefix(name, "Emotions.") {
+		emotion := strings.TrimPrefix(name, "Emotions.")
+		for _, known := range faceEmotionNames {
+			if known == emotion {
+				return faceField{faceFieldNumber, func(f types.FaceDetail) (interface{}, bool) {
+					if f.Emotions == nil {
+						return nil, false
+					}
+					for _, e := range f.Emotions {
+						if string(e.Type) == emotion {
+							return float32Field(e.Confidence)
+						}
+					}
+					return float64(0), true
+				}}, nil
+			}
+		}
+		return faceField{}, fmt.Errorf("Unknown emotion %v", emotion)
+	}
+	return faceField{}, fmt.Errorf("Unknown face field %v", name)
+}
+
+func (c EnhancementCondition) validate() error {
+	field, err := lookupFaceField(c.Field)
+	if err != nil {
+		return err
+	}
+	switch field.Kind {
+	case faceFieldBool:
+		if c.Is == nil || c.Equals != "" || c.Min != nil || c.Max != nil {
+			return fmt.Errorf("Condition on %v must only test is", c.Field)
+		}
+	case faceFieldString:
+		if c.Equals == "" || c.Is != nil || c.Min != nil || c.Max != nil {
+			return fmt.Errorf("Condition on %v must only test equals", c.Field)
+		}
+	case faceFieldNumber:
+		if (c.Min == nil && c.Max == nil) || c.Is != nil || c.Equals != "" {
+			return fmt.Errorf("Condition on %v must only test min and max", c.Field)
+		}
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			return fmt.Errorf("Condition on %v has a min above its max", c.Field)
+		}
+	}
+	return nil
+}
+
+// Whether the face meets the condition. Conditions are validated when the catalogue is loaded, so an unknown field never holds.
+func (c EnhancementCondition) Holds(faceDetails types.FaceDetail) bool {
+	field, err := lookupFaceField(c.Field)
+	if err != nil {
+		return false
+	}
+	value, found := field.Get(faceDetails)
+	if !found {
+		return false
+	}
+	switch field.Kind {
+	case faceFieldBool:
+		return c.Is != nil && value.(bool) == *c.Is
+	case faceFieldString:
+		return value.(string) == c.Equals
+	default:
+		number := value.(float64)
+		return (c.Min == nil || number >= *c.Min) && (c.Max == nil || number <= *c.Max)
+	}
+}
+
+func (r EnhancementRule) validate() error {
+	switch r.Effect {
+	case RuleRequire, RuleForce, RuleForbid:
+		if r.Weight != 0 {
+			return fmt.Errorf("Rule %v has a weight, which only weight rules use", r.Effect)
+		}
+	case RuleWeight:
+		if r.Weight < 0 {
+			return errors.New("Rule weight is negative")
+		}
+	default:
+		return fmt.Errorf("Unknown rule effect %v", r.Effect)
+	}
+	if len(r.When) == 0 {
+		return fmt.Errorf("Rule %v has no conditions", r.Effect)
+	}
+	for _, condition := range r.When {
+		if err := condition.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the face meets every condition of the rule.
+func (r EnhancementRule) Matches(faceDetails types.FaceDetail) bool {
+	for _, condition := range r.When {
+		if !condition.Holds(faceDetails) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate rules against a face. A face that fails a require rule or matches a forbid rule is not eligible, even if a force rule matches too.
+// The weights of matching weight rules are multiplied together.
+func evaluateRules(rules []EnhancementRule, faceDetails types.FaceDetail) RuleOutcome {
+	outcome := RuleOutcome{Eligible: true, Weight: 1}
+	for _, rule := range rules {
+		matches := rule.Matches(faceDetails)
+		switch rule.Effect {
+		case RuleRequire:
+			if !matches {
+				return RuleOutcome{}
+			}
+		case RuleForbid:
+			if matches {
+				return RuleOutcome{}
+			}
+		case RuleForce:
+			outcome.Forced = outcome.Forced || matches
+		case RuleWeight:
+			if matches {
+				outcome.Weight *= rule.Weight
+			}
+		}
+	}
+	return outcome
+}
+
+// The effect of an enhancement's rules on a face. The gender requirement is a require rule on Gender.
+func (e Enhancement) Evaluate(faceDetails types.FaceDetail) RuleOutcome {
+	rules := e.Rules
+	if e.GenderRequirement != "" {
+		rules = append([]EnhancementRule{{
+			When:   []EnhancementCondition{{Field: "Gender", Equals: e.GenderRequirement}},
+			Effect: RuleRequire,
+		}}, rules...)
+	}
+	return evaluateRules(rules, faceDetails)
+}
+
+func (t EnhancementType) Evaluate(faceDetails types.FaceDetail) RuleOutcome {
+	return evaluateRules(t.Rules, faceDetails)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+func TestEnhancementConditionHolds(t *testing.T) {
+	yes := true
+	min, max := 20.0, 40.0
+	yaw := float32(-25)
+	happy := float32(80)
+	low := int32(30)
+	faceDetails := types.FaceDetail{
+		AgeRange: &types.AgeRange{Low: &low},
+		Gender:   &types.Gender{Value: types.GenderTypeFemale},
+		Smile:    &types.Smile{Value: true},
+		Pose:     &types.Pose{Yaw: &yaw},
+		Emotions: []types.Emotion{{Type: "HAPPY", Confidence: &happy}},
+	}
+	for _, tc := range []struct {
+		condition EnhancementCondition
+		holds     bool
+	}{
+		{EnhancementCondition{Field: "AgeRange.Low", Min: &min, Max: &max}, true},
+		{EnhancementCondition{Field: "AgeRange.High", Min: &min}, false}, // Not in the face analysis data
+		{EnhancementCondition{Field: "Gender", Equals: "Female"}, true},
+		{EnhancementCondition{Field: "Gender", Equals: "Male"}, false},
+		{EnhancementCondition{Field: "Smile", Is: &yes}, true},
+		{EnhancementCondition{Field: "Beard", Is: &yes}, false},
+		{EnhancementCondition{Field: "Pose.Yaw", Min: &min}, false},
+		{EnhancementCondition{Field: "Emotions.HAPPY", Min: &min}, true},
+		{EnhancementCondition{Field: "Emotions.SAD", Max: &min}, true},
+	} {
+		if err := tc.condition.validate(); err != nil {
+			t.Errorf("%+v: unexpected error %v", tc.condition, err)
+		}
+		if tc.condition.Holds(faceDetails) != tc.holds {
+			t.Errorf("%+v: expected holds to be %v", tc.condition, tc.holds)
+		}
+	}
+
+	for _, invalid := range []EnhancementCondition{
+		{Field: "Hair", Is: &yes},
+		{Field: "Emotions.BORED", Min: &min},
+		{Field: "Beard", Min: &min},
+		{Field: "Gender", Is: &yes},
+		{Field: "AgeRange.Low"},
+		{Field: "AgeRange.Low", Min: &max, Max: &min},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("%+v: expected a validation error", invalid)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	yes := true
+	beard := []EnhancementCondition{{Field: "Beard", Is: &yes}}
+	mustache := []EnhancementCondition{{Field: "Mustache", Is: &yes}}
+	faceDetails := types.FaceDetail{
+		Beard:    &types.Beard{Value: true},
+		Mustache: &types.Mustache{Value: false},
+	}
+	for _, tc := range []struct {
+		name    string
+		rules   []EnhancementRule
+		outcome RuleOutcome
+	}{
+		{"none", nil, RuleOutcome{Eligible: true, Weight: 1}},
+		{"force", []EnhancementRule{{When: beard, Effect: RuleForce}}, RuleOutcome{Eligible: true, Forced: true, Weight: 1}},
+		{"forbid-wins", []EnhancementRule{{When: beard, Effect: RuleForce}, {When: beard, Effect: RuleForbid}}, RuleOutcome{}},
+		{"require", []EnhancementRule{{When: mustache, Effect: RuleRequire}}, RuleOutcome{}},
+		{"weights", []EnhancementRule{{When: beard, Effect: RuleWeight, Weight: 2}, {When: beard, Effect: RuleWeight, Weight: 1.5}, {When: mustache, Effect: RuleWeight, Weight: 10}}, RuleOutcome{Eligible: true, Weight: 3}},
+	} {
+		if outcome := evaluateRules(tc.rules, faceDetails); outcome != tc.outcome {
+			t.Errorf("%v: expected %+v, got %+v", tc.name, tc.outcome, outcome)
+		}
+	}
+}
+
+func TestChooseEnhancementTypeRules(t *testing.T) {
+	yes := true
+	beard := []EnhancementCondition{{Field: "Beard", Is: &yes}}
+	enhancement := Enhancement{Name: "Beards", Probability: 1, Types: []EnhancementType{
+		{Name: "Full beard", Probability: 1, Rules: []EnhancementRule{{When: beard, Effect: RuleForbid}}},
+		{Name: "Goatee", Probability: 0.01},
+		{Name: "Lion", Probability: 0.01, Rules: []EnhancementRule{{When: beard, Effect: RuleForce}}},
+	}}
+	faceDetails := types.FaceDetail{Beard: &types.Beard{Value: true}}
+	for i := 0; i < 20; i++ {
+		if eType, found := chooseEnhancementType(enhancement, faceDetails, rand.New(rand.NewSource(int64(i))), nil); !found || eType.Name != "Lion" {
+			t.Fatalf("Expected the forced type, got %+v", eType)
+		}
+	}
+
+	enhancement.Types[2].Rules[0].Effect = RuleForbid
+	enhancement.Types[1].Rules = []EnhancementRule{{When: beard, Effect: RuleForbid}}
+	if eType, found := chooseEnhancementType(enhancement, faceDetails, rand.New(rand.NewSource(1)), nil); found {
+		t.Errorf("Expected no type when every type is forbidden, got %+v", eType)
+	}
+}
+
+func TestDefaultCatalogueBeards(t *testing.T) {
+	var beards Enhancement
+	for _, enhancement := range enhancements {
+		if enhancement.Name == "Beards" {
+			beards = enhancement
+		}
+	}
+	bearded := types.FaceDetail{
+		Gender:   &types.Gender{Value: types.GenderTypeMale},
+		Beard:    &types.Beard{Value: true},
+		Mustache: &types.Mustache{Value: false},
+	}
+	for i := 0; i < 20; i++ {
+		if eType, found := chooseEnhancementType(beards, bearded, rand.New(rand.NewSource(int64(i))), nil); !found || eType.Name != "Full beard" {
+			t.Fatalf("Expected a face with a beard to get a full beard, got %+v", eType)
+		}
+	}
+	if !preparesBeards(beards, bearded) {
+		t.Error("Expected a male face with a beard to be prepared for beards")
+	}
+
+	mustache := types.FaceDetail{
+		Gender:   &types.Gender{Value: types.GenderTypeMale},
+		Beard:    &types.Beard{Value: false},
+		Mustache: &types.Mustache{Value: true},
+	}
+	if !preparesBeards(beards, mustache) {
+		t.Error("Expected a male face with a mustache to be prepared for beards")
+	}
+
+	// The gender requirement rules out faces that are not analysed as Male, whatever their beard
+	bearded.Gender = &types.Gender{Value: types.GenderTypeFemale}
+	if preparesBeards(beards, bearded) {
+		t.Error("Expected a female face with a beard not to be prepared for beards")
+	}
+}
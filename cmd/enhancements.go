@@ -1,158 +1,217 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"math/rand"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+	cli "github.com/spf13/cobra"
+)
+
+const (
+	defaultEnhancementCatalogueFile = "./assets/faceapp/enhancements.json"
+	// Bumped when the catalogue file format changes incompatibly.
+	enhancementCatalogueVersion = 1
+	enhancementCatalogueRunFile = "enhancements.json"
 )
 
 type EnhancementType struct {
-	Name              string
-	Probability       float64
-	ScrollRequirement int
+	Name              string            `json:"name"`
+	Probability       float64           `json:"probability"`
+	ScrollRequirement int               `json:"scrollRequirement,omitempty"`
+	Rules             []EnhancementRule `json:"rules,omitempty"`
+	Quota             *EnhancementQuota `json:"quota,omitempty"`
 }
 
 type Enhancement struct {
-	Name              string
-	Probability       float64
-	Types             []EnhancementType
-	GenderRequirement string
+	Name              string            `json:"name"`
+	Disabled          bool              `json:"disabled,omitempty"`
+	Probability       float64           `json:"probability"`
+	Types             []EnhancementType `json:"types"`
+	GenderRequirement string            `json:"genderRequirement,omitempty"`
+	Rules             []EnhancementRule `json:"rules,omitempty"`
+	Quota             *EnhancementQuota `json:"quota,omitempty"`
+}
+
+// EnhancementCatalogue is the enhancements that can be chosen for a face, loaded from a file such as assets/faceapp/enhancements.json.
+// Disabled enhancements stay in the file so they can be switched on without being written out again.
+type EnhancementCatalogue struct {
+	Version      int           `json:"version"`
+	Enhancements []Enhancement `json:"enhancements"`
 }
 
 var (
-	enhancements = []Enhancement{
-		{
-			Name:              "Beards",
-			Probability:       0.5,
-			GenderRequirement: "Male",
-			Types: []EnhancementType{
-				{
-					Name:        "Full beard",
-					Probability: 0.3,
-				},
-				{
-					Name:        "Hipster",
-					Probability: 0.2,
-				},
-				{
-					Name:        "Goatee",
-					Probability: 0.2,
-				},
-				{
-					Name:        "Mustache",
-					Probability: 0.2,
-				},
-				{
-					Name:        "Grand goatee",
-					Probability: 0.2,
-				},
-				{
-					Name:        "Lion",
-					Probability: 0.05,
-				},
-				{
-					Name:        "Petite Goatee",
-					Probability: 0.05,
-				},
-			},
-		},
-		// {
-		// 	Name:              "Makeup",
-		// 	Probability:       0.5,
-		// 	GenderRequirement: "Female",
-		// 	Types: []EnhancementType{
-		// 		{
-		// 			Name:        "Makeup 3",
-		// 			Probability: 0.05,
-		// 		},
-		// 		{
-		// 			Name:        "Makeup 4",
-		// 			Probability: 0.05,
-		// 		},
-		// 		{
-		// 			Name:        "Contouring",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:        "Blush",
-		// 			Probability: 0.05,
-		// 		},
-		// 		{
-		// 			Name:        "Eyelashes",
-		// 			Probability: 0.2,
-		// 		},
-		// 		{
-		// 			Name:        "Eyebrows",
-		// 			Probability: 0.3,
-		// 		},
-		// 		{
-		// 			Name:        "Eyeliner",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:        "Foundation",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:        "No makeup",
-		// 			Probability: 0.5,
-		// 		},
-		// 		{
-		// 			Name:        "Glossy",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:        "Eyeshadows",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:              "Dark Matte",
-		// 			Probability:       0.1,
-		// 			ScrollRequirement: 800,
-		// 		},
-		// 		{
-		// 			Name:              "Dark",
-		// 			Probability:       0.1,
-		// 			ScrollRequirement: 800,
-		// 		},
-		// 		{
-		// 			Name:              "Bright Glossy",
-		// 			Probability:       0.1,
-		// 			ScrollRequirement: 800,
-		// 		},
-		// 		{
-		// 			Name:              "Dark Glossy",
-		// 			Probability:       0.1,
-		// 			ScrollRequirement: 800,
-		// 		},
-		// 	},
-		// },
-		// {
-		// 	Name:        "Sizes",
-		// 	Probability: 0.3,
-		// 	Types: []EnhancementType{
-		// 		{
-		// 			Name:        "Big Face",
-		// 			Probability: 0.5,
-		// 		},
-		// 		{
-		// 			Name:        "Cheekbones",
-		// 			Probability: 0.1,
-		// 		},
-		// 		{
-		// 			Name:        "Small Face",
-		// 			Probability: 0.01,
-		// 		},
-		// 	},
-		// },
-	}
+	// The enabled enhancements of the catalogue in use, in the order they are applied.
+	enhancements []Enhancement
 )
 
+// Load a catalogue file and validate it against the UI elements of an asset pack.
+func LoadEnhancementCatalogue(cataloguePath string, manifest *AssetManifest) (*EnhancementCatalogue, error) {
+	file, err := ioutil.ReadFile(cataloguePath)
+	if err != nil {
+		return nil, err
+	}
+	catalogue := &EnhancementCatalogue{}
+	err = json.Unmarshal(file, catalogue)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, cataloguePath)
+	}
+	err = catalogue.validate(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, cataloguePath)
+	}
+	return catalogue, nil
+}
+
+func (c *EnhancementCatalogue) validate(manifest *AssetManifest) error {
+	if c.Version != enhancementCatalogueVersion {
+		return fmt.Errorf("Enhancement catalogue version %d is not supported - expected %d", c.Version, enhancementCatalogueVersion)
+	}
+	if len(c.Enabled()) == 0 {
+		return errors.New("Enhancement catalogue has no enabled enhancements")
+	}
+	names := map[string]bool{}
+	for _, enhancement := range c.Enhancements {
+		if enhancement.Name == "" {
+			return errors.New("Enhancement has no name")
+		}
+		if names[enhancement.Name] {
+			return fmt.Errorf("Enhancement %v is listed more than once", enhancement.Name)
+		}
+		names[enhancement.Name] = true
+		if enhancement.Probability < 0 || enhancement.Probability > 1 {
+			return fmt.Errorf("Enhancement %v has a probability outside of 0 to 1", enhancement.Name)
+		}
+		switch enhancement.GenderRequirement {
+		case "", string(types.GenderTypeMale), string(types.GenderTypeFemale):
+		default:
+			return fmt.Errorf("Enhancement %v has unknown gender requirement %v", enhancement.Name, enhancement.GenderRequirement)
+		}
+		for _, rule := range enhancement.Rules {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("Enhancement %v - %v", enhancement.Name, err)
+			}
+		}
+		if err := enhancement.Quota.validate(); err != nil {
+			return fmt.Errorf("Enhancement %v - %v", enhancement.Name, err)
+		}
+		if len(enhancement.Types) == 0 {
+			return fmt.Errorf("Enhancement %v has no types", enhancement.Name)
+		}
+		if _, err := manifest.Element(enhancementElement(enhancement.Name)); err != nil {
+			return fmt.Errorf("Enhancement %v has no asset - %v", enhancement.Name, err)
+		}
+		typeNames := map[string]bool{}
+		hasScrollReference := false
+		for _, eType := range enhancement.Types {
+			if eType.Name == "" {
+				return fmt.Errorf("Enhancement %v has a type without a name", enhancement.Name)
+			}
+			if typeNames[eType.Name] {
+				return fmt.Errorf("Enhancement %v type %v is listed more than once", enhancement.Name, eType.Name)
+			}
+			typeNames[eType.Name] = true
+			if eType.Probability < 0 || eType.Probability > 1 {
+				return fmt.Errorf("Enhancement %v type %v has a probability outside of 0 to 1", enhancement.Name, eType.Name)
+			}
+			for _, rule := range eType.Rules {
+				if err := rule.validate(); err != nil {
+					return fmt.Errorf("Enhancement %v type %v - %v", enhancement.Name, eType.Name, err)
+				}
+			}
+			if err := eType.Quota.validate(); err != nil {
+				return fmt.Errorf("Enhancement %v type %v - %v", enhancement.Name, eType.Name, err)
+			}
+			if eType.ScrollRequirement < 0 {
+				return fmt.Errorf("Enhancement %v type %v has a negative scroll requirement", enhancement.Name, eType.Name)
+			}
+			if eType.ScrollRequirement == 0 {
+				hasScrollReference = true
+			}
+			if _, err := manifest.Element(enhancementTypeElement(enhancement.Name, eType.Name)); err != nil {
+				return fmt.Errorf("Enhancement %v type %v has no asset - %v", enhancement.Name, eType.Name, err)
+			}
+		}
+		// Types that need a scroll are found relative to a type that is shown without one.
+		if !hasScrollReference {
+			return fmt.Errorf("Enhancement %v has no type shown without scrolling", enhancement.Name)
+		}
+	}
+	return nil
+}
+
+// The enabled enhancements, in the order they are applied.
+func (c *EnhancementCatalogue) Enabled() []Enhancement {
+	var enabled []Enhancement
+	for _, enhancement := range c.Enhancements {
+		if !enhancement.Disabled {
+			enabled = append(enabled, enhancement)
+		}
+	}
+	return enabled
+}
+
+// Find an enhancement by name, whether or not it is enabled.
+func (c *EnhancementCatalogue) Enhancement(name string) (Enhancement, bool) {
+	for _, enhancement := range c.Enhancements {
+		if enhancement.Name == name {
+			return enhancement, true
+		}
+	}
+	return Enhancement{}, false
+}
+
+// A copy of the catalogue with every enhancement and type certain to be chosen, as used in debug mode.
+func (c *EnhancementCatalogue) WithMaxProbabilities() *EnhancementCatalogue {
+	maxed := &EnhancementCatalogue{Version: c.Version}
+	for _, enhancement := range c.Enhancements {
+		enhancement.Probability = 1
+		enhancement.Types = append([]EnhancementType{}, enhancement.Types...)
+		for i := range enhancement.Types {
+			enhancement.Types[i].Probability = 1
+		}
+		maxed.Enhancements = append(maxed.Enhancements, enhancement)
+	}
+	return maxed
+}
+
+func (c *EnhancementCatalogue) Save(cataloguePath string) error {
+	catalogueJson, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cataloguePath, catalogueJson, 0644)
+}
+
+// Load the catalogue of the --enhancements flag and use its enabled enhancements.
+func useEnhancementCatalogueFlag(cmd *cli.Command, manifest *AssetManifest) *EnhancementCatalogue {
+	cataloguePath, _ := cmd.Flags().GetString("enhancements")
+	catalogue, err := LoadEnhancementCatalogue(cataloguePath, manifest)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load enhancement catalogue - %v", err.Error())
+	}
+	if debugMode {
+		catalogue = catalogue.WithMaxProbabilities()
+	}
+	enhancements = catalogue.Enabled()
+	return catalogue
+}
+
 // Shuffle the Types and return a new Types array
 func (e *Enhancement) ShuffleTypes() []EnhancementType {
+	return e.ShuffleTypesWith(rand.New(rand.NewSource(time.Now().Unix())))
+}
+
+// Shuffle the types with a given source of randomness, so that a seeded plan always shuffles the same way.
+func (e *Enhancement) ShuffleTypesWith(r *rand.Rand) []EnhancementType {
 	var vals []EnhancementType
 	vals = append(vals, e.Types...) // Cleaner copy
 
-	r := rand.New(rand.NewSource(time.Now().Unix()))
 	ret := make([]EnhancementType, len(vals))
 	n := len(vals)
 	for i := 0; i < n; i++ {
@@ -162,3 +221,22 @@ func (e *Enhancement) ShuffleTypes() []EnhancementType {
 	}
 	return ret
 }
+
+// Load the catalogue of the --enhancements flag and find an enhancement in it, whether or not it is enabled.
+// Used by the commands that prepare images for an enhancement by its rules.
+func catalogueEnhancementFlag(cmd *cli.Command, name string) Enhancement {
+	manifest, err := LoadAssetManifest(faceappAssetsDir)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load asset manifest - %v", err.Error())
+	}
+	cataloguePath, _ := cmd.Flags().GetString("enhancements")
+	catalogue, err := LoadEnhancementCatalogue(cataloguePath, manifest)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load enhancement catalogue - %v", err.Error())
+	}
+	enhancement, found := catalogue.Enhancement(name)
+	if !found {
+		log.Fatalf("ERROR: Enhancement catalogue %v has no %v enhancement", cataloguePath, name)
+	}
+	return enhancement
+}
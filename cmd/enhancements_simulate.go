@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+	cli "github.com/spf13/cobra"
+)
+
+var (
+	enhancementsCmd = &cli.Command{
+		Use:   "enhancements",
+		Short: "Inspect the enhancement catalogue.",
+	}
+	enhancementsSimulateCmd = &cli.Command{
+		Use:   "simulate",
+		Short: "Simulate the enhancement selection over a facedata directory many times, and report the expected count and variance of each enhancement type by gender and age.",
+		Run:   SimulateEnhancementsCmd,
+	}
+
+	// Upper bounds of the age buckets the simulation is reported in, by the low end of each face's age range.
+	simulationAgeBuckets = []int32{25, 35, 50}
+)
+
+func init() {
+	rootCmd.AddCommand(enhancementsCmd)
+	enhancementsCmd.AddCommand(enhancementsSimulateCmd)
+
+	enhancementsCmd.PersistentFlags().String("enhancements", defaultEnhancementCatalogueFile, "Path to the enhancement catalogue -- the enhancements and types that can be chosen, their probabilities, gender requirements and rules.")
+	enhancementsSimulateCmd.Flags().String("policy", defaultSafetyPolicyFile, "Path to the safety policy -- the minimum age, exclusion lists and face quality and pose limits of the images that can be selected.")
+	enhancementsSimulateCmd.Flags().StringP("facedata", "f", "", "Path to AWS Face Analysis dataset directory.")
+	enhancementsSimulateCmd.Flags().Int("runs", 1000, "Number of times to simulate the selection over the whole dataset.")
+	enhancementsSimulateCmd.Flags().Int64("seed", 0, "Seed of the simulation. Defaults to the current timestamp.")
+	_ = enhancementsSimulateCmd.MarkFlagRequired("facedata")
+}
+
+// SimulationGroup is the faces of a gender and age bucket.
+type SimulationGroup struct {
+	Gender    string
+	AgeBucket int // Index into simulationAgeBuckets, or len(simulationAgeBuckets) for the oldest bucket.
+}
+
+func (g SimulationGroup) AgeLabel() string {
+	min := int32(enhanceMinAge)
+	if g.AgeBucket > 0 {
+		min = simulationAgeBuckets[g.AgeBucket-1]
+	}
+	if g.AgeBucket == len(simulationAgeBuckets) {
+		return fmt.Sprintf("%d+", min)
+	}
+	return fmt.Sprintf("%d-%d", min, simulationAgeBuckets[g.AgeBucket]-1)
+}
+
+// SimulatedType is how often an enhancement type is chosen for the faces of a group in one run of the whole dataset.
+type SimulatedType struct {
+	Group       SimulationGroup
+	Faces       int
+	Enhancement string
+	Type        string
+	Mean        float64
+	Variance    float64
+}
+
+func SimulateEnhancementsCmd(cmd *cli.Command, args []string) {
+	facedataDir, _ := cmd.Flags().GetString("facedata")
+	runs, _ := cmd.Flags().GetInt("runs")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	if seed == 0 {
+		seed = currentTs
+	}
+	if runs < 1 {
+		log.Fatalln("ERROR: At least one run is required")
+	}
+
+	manifest, err := LoadAssetManifest(faceappAssetsDir)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load asset manifest - %v", err.Error())
+	}
+	useEnhancementCatalogueFlag(cmd, manifest)
+	useSafetyPolicyFlag(cmd)
+
+	facedataPaths, err := filepath.Glob(path.Join(facedataDir, "/*.json"))
+	if err != nil {
+		log.Fatal("ERROR: ", err.Error())
+	}
+	faces := map[string]types.FaceDetail{}
+	skipped := map[string]int{}
+	for _, facedataPath := range facedataPaths {
+		imageId := getFileName(facedataPath)
+		facedata, err := loadFaceData([]string{facedataPath}, imageId)
+		if err != nil {
+			log.Printf("WARN: Skipping image %v - %v\n", imageId, err.Error())
+			continue
+		}
+		if reason := safetyPolicy.Check(imageId, facedata); reason != "" {
+			skipped[reason]++
+			continue
+		}
+		faces[imageId] = facedata.FaceDetails[0]
+	}
+	log.Printf("Simulating enhancements of %d faces %d times with seed %d, skipping %v\n", len(faces), runs, seed, policySkipSummary(skipped))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GENDER\tAGE\tFACES\tENHANCEMENT\tTYPE\tEXPECTED\tVARIANCE")
+	for _, simulated := range SimulateEnhancements(faces, runs, seed) {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%.2f\t%.2f\n", simulated.Group.Gender, simulated.Group.AgeLabel(), simulated.Faces, simulated.Enhancement, simulated.Type, simulated.Mean, simulated.Variance)
+	}
+	w.Flush()
+}
+
+// Run the enhancement selection over every face the given number of times.
+// Each run chooses the enhancements of every face exactly as a planned enhancement run would, quotas included, and the count of each type chosen in a group is averaged over the runs.
+// Every type a group can be given is reported, including those never chosen.
+func SimulateEnhancements(faces map[string]types.FaceDetail, runs int, seed int64) []SimulatedType {
+	imageIds := make([]string, 0, len(faces))
+	for imageId := range faces {
+		imageIds = append(imageIds, imageId)
+	}
+	sort.Strings(imageIds) // Map order is random, so sort to keep a seed reproducible.
+
+	type typeKey struct {
+		Group       SimulationGroup
+		Enhancement string
+		Type        string
+	}
+	groupFaces := map[SimulationGroup]int{}
+	for _, imageId := range imageIds {
+		groupFaces[simulationGroup(faces[imageId])]++
+	}
+	var groups []SimulationGroup
+	for group := range groupFaces {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Gender != groups[j].Gender {
+			return groups[i].Gender < groups[j].Gender
+		}
+		return groups[i].AgeBucket < groups[j].AgeBucket
+	})
+
+	var keys []typeKey
+	for _, group := range groups {
+		for _, enhancement := range enhancements {
+			if enhancement.GenderRequirement != "" && enhancement.GenderRequirement != group.Gender {
+				continue
+			}
+			for _, eType := range enhancement.Types {
+				keys = append(keys, typeKey{group, enhancement.Name, eType.Name})
+			}
+		}
+	}
+
+	sums := map[typeKey]float64{}
+	sumSquares := map[typeKey]float64{}
+	for run := 0; run < runs; run++ {
+		r := rand.New(rand.NewSource(seed + int64(run)))
+		// Each run is a collection of its own, so quotas start from nothing
+		quotas := NewQuotaTracker()
+		for _, imageId := range imageIds {
+			quotas.Expect(imageId, faces[imageId])
+		}
+		counts := map[typeKey]float64{}
+		for _, imageId := range imageIds {
+			faceDetails := faces[imageId]
+			group := simulationGroup(faceDetails)
+			selected := chooseEnhancements(faceDetails, r, quotas)
+			quotas.Pass(imageId)
+			quotas.Record(IndexedImage{Id: imageId, Enhancements: selectedEnhancementsIndex(selected)})
+			for _, s := range selected {
+				counts[typeKey{group, s.Enhancement.Name, s.Type.Name}]++
+			}
+		}
+		for key, count := range counts {
+			sums[key] += count
+			sumSquares[key] += count * count
+		}
+	}
+
+	var simulated []SimulatedType
+	for _, key := range keys {
+		mean := sums[key] / float64(runs)
+		simulated = append(simulated, SimulatedType{
+			Group:       key.Group,
+			Faces:       groupFaces[key.Group],
+			Enhancement: key.Enhancement,
+			Type:        key.Type,
+			Mean:        mean,
+			Variance:    math.Max(0, sumSquares[key]/float64(runs)-mean*mean), // Clamp rounding errors of types that are always chosen
+		})
+	}
+	return simulated
+}
+
+// The group of a face that is of age to be enhanced.
+func simulationGroup(faceDetails types.FaceDetail) SimulationGroup {
+	group := SimulationGroup{Gender: "Unknown"}
+	if faceDetails.Gender != nil {
+		group.Gender = string(faceDetails.Gender.Value)
+	}
+	for group.AgeBucket < len(simulationAgeBuckets) && *faceDetails.AgeRange.Low >= simulationAgeBuckets[group.AgeBucket] {
+		group.AgeBucket++
+	}
+	return group
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+func TestSimulateEnhancements(t *testing.T) {
+	age := func(low int32) *types.AgeRange { return &types.AgeRange{Low: &low} }
+	faces := map[string]types.FaceDetail{
+		"1": {AgeRange: age(20), Gender: &types.Gender{Value: types.GenderTypeMale}, Beard: &types.Beard{Value: true}},
+		"2": {AgeRange: age(22), Gender: &types.Gender{Value: types.GenderTypeMale}, Mustache: &types.Mustache{Value: true}},
+		"3": {AgeRange: age(60), Gender: &types.Gender{Value: types.GenderTypeMale}},
+		"4": {AgeRange: age(30), Gender: &types.Gender{Value: types.GenderTypeFemale}},
+	}
+	simulated := SimulateEnhancements(faces, 200, 3)
+	if !reflect.DeepEqual(simulated, SimulateEnhancements(faces, 200, 3)) {
+		t.Fatal("Expected the same seed to simulate the same distribution")
+	}
+
+	totals := map[SimulationGroup]float64{}
+	for _, s := range simulated {
+		if s.Group.Gender == string(types.GenderTypeFemale) && s.Enhancement == "Beards" {
+			t.Errorf("Expected no beards for female faces, got %+v", s)
+		}
+		if s.Variance < 0 {
+			t.Errorf("Expected a non-negative variance, got %+v", s)
+		}
+		totals[s.Group] += s.Mean
+	}
+	// Both faces with a beard or mustache always get one, and exactly one type of it.
+	young := SimulationGroup{Gender: string(types.GenderTypeMale), AgeBucket: 0}
+	if math.Abs(totals[young]-2) > 1e-9 {
+		t.Errorf("Expected 2 beards per run for %v, got %v", young.AgeLabel(), totals[young])
+	}
+	// Other male faces get a beard by its probability.
+	old := SimulationGroup{Gender: string(types.GenderTypeMale), AgeBucket: len(simulationAgeBuckets)}
+	if totals[old] < 0.35 || totals[old] > 0.65 {
+		t.Errorf("Expected about 0.5 beards per run for %v, got %v", old.AgeLabel(), totals[old])
+	}
+	if young.AgeLabel() != "16-24" || old.AgeLabel() != "50+" {
+		t.Errorf("Unexpected age labels %v and %v", young.AgeLabel(), old.AgeLabel())
+	}
+}
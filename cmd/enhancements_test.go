@@ -1,9 +1,29 @@
 package main
 
 import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
+// Tests choose enhancements from the catalogue in the asset pack.
+func TestMain(m *testing.M) {
+	cwd, _ := os.Getwd()
+	manifest, err := LoadAssetManifest(path.Join(cwd, "../", faceappAssetsDir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalogue, err := LoadEnhancementCatalogue(path.Join(cwd, "../", defaultEnhancementCatalogueFile), manifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enhancements = catalogue.Enabled()
+	os.Exit(m.Run())
+}
+
 // Shuffle the Types and return a new Types array
 func TestShuffleTypes(t *testing.T) {
 	for _, enh := range enhancements {
@@ -29,3 +49,57 @@ func TestShuffleTypes(t *testing.T) {
 		// }
 	}
 }
+
+func TestEnhancementCatalogueValidation(t *testing.T) {
+	manifest := loadTestAssetManifest(t)
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{"valid", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "genderRequirement": "Male", "types": [{"name": "Goatee", "probability": 0.2}, {"name": "Lion", "probability": 0.1, "scrollRequirement": 400}]}, {"name": "Sizes", "disabled": true, "probability": 0.3, "types": [{"name": "Big Face", "probability": 0.5}]}]}`, ""},
+		{"version", `{"version": 2, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "version"},
+		{"all-disabled", `{"version": 1, "enhancements": [{"name": "Beards", "disabled": true, "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "no enabled"},
+		{"no-enhancement-asset", `{"version": 1, "enhancements": [{"name": "Tattoos", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "no asset"},
+		{"no-type-asset", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Handlebar", "probability": 0.2}]}]}`, "no asset"},
+		{"probability", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 1.5, "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "probability"},
+		{"gender", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "genderRequirement": "male", "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "gender"},
+		{"scroll-reference", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2, "scrollRequirement": 400}]}]}`, "without scrolling"},
+		{"rule", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "rules": [{"when": [{"field": "Beard", "is": true}], "effect": "always"}], "types": [{"name": "Goatee", "probability": 0.2}]}]}`, "Unknown rule effect"},
+		{"type-rule", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2, "rules": [{"when": [{"field": "Beard", "min": 1}], "effect": "force"}]}]}]}`, "must only test is"},
+		{"quota", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2, "quota": {"exactly": 25, "max": 30}}]}]}`, "cannot also have a max"},
+		{"duplicate-type", `{"version": 1, "enhancements": [{"name": "Beards", "probability": 0.5, "types": [{"name": "Goatee", "probability": 0.2}, {"name": "Goatee", "probability": 0.1}]}]}`, "more than once"},
+	} {
+		cataloguePath := path.Join(dir, tc.name+".json")
+		err := ioutil.WriteFile(cataloguePath, []byte(tc.contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalogue, err := LoadEnhancementCatalogue(cataloguePath, manifest)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", tc.name, err)
+			} else if enabled := catalogue.Enabled(); len(enabled) != 1 || enabled[0].Name != "Beards" {
+				t.Errorf("%v: unexpected enabled enhancements %+v", tc.name, enabled)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestEnhancementCatalogueWithMaxProbabilities(t *testing.T) {
+	catalogue := &EnhancementCatalogue{Version: enhancementCatalogueVersion, Enhancements: []Enhancement{
+		{Name: "Beards", Probability: 0.5, Types: []EnhancementType{{Name: "Goatee", Probability: 0.2}}},
+	}}
+	maxed := catalogue.WithMaxProbabilities()
+	if maxed.Enhancements[0].Probability != 1 || maxed.Enhancements[0].Types[0].Probability != 1 {
+		t.Errorf("Expected every probability to be 1, got %+v", maxed.Enhancements)
+	}
+	if catalogue.Enhancements[0].Probability != 0.5 || catalogue.Enhancements[0].Types[0].Probability != 0.2 {
+		t.Errorf("Expected the original catalogue to be unchanged, got %+v", catalogue.Enhancements)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	cli "github.com/spf13/cobra"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// Faces narrower than this fraction of the screenshot are ignored -- 300 pixels on the 3584 pixel wide screen the detection was tuned on.
+	// This prevents face detection in hair, and in the small before & after images of the save screen.
+	faceMinWidth = 300.0 / 3584.0
+	// Detections that overlap by more than this are merged into a single face.
+	faceMaxOverlap = 0.3
+	// Detections where this much of the smaller one is inside of the larger one are also merged.
+	faceMaxContainment = 0.8
+
+	// OpenCV groups the raw cascade detections itself, keeping faces made up of at least this many.
+	cascadeMinNeighbours = 3
+
+	// The res10 SSD model is trained on 300x300 BGR images with the mean subtracted.
+	dnnInputSize = 300
+)
+
+// FaceDetection is a single face found in a screenshot, in pixels of the screenshot.
+type FaceDetection struct {
+	Rect       image.Rectangle `json:"rect"`
+	Confidence float32         `json:"confidence"`
+}
+
+// FaceDetector finds faces in a screenshot. Detections may overlap -- BlueStacks merges and filters them.
+type FaceDetector interface {
+	Detect(img image.Image) ([]FaceDetection, error)
+	Close() error
+}
+
+// CascadeFaceDetector uses a Haar or LBP cascade such as those in ./opencv.
+// Cascades do not score their detections -- gocv does not expose the level weights of detectMultiScale3 -- so every face is found with full confidence.
+type CascadeFaceDetector struct {
+	Classifier gocv.CascadeClassifier
+}
+
+func NewCascadeFaceDetector(cascadeFile string) (*CascadeFaceDetector, error) {
+	classifier := gocv.NewCascadeClassifier()
+	if !classifier.Load(cascadeFile) {
+		classifier.Close()
+		return nil, fmt.Errorf("Error reading cascade file: %v", cascadeFile)
+	}
+	return &CascadeFaceDetector{Classifier: classifier}, nil
+}
+
+func (d *CascadeFaceDetector) Detect(img image.Image) ([]FaceDetection, error) {
+	imgMat, err := gocv.ImageToMatRGB(img)
+	if err != nil {
+		return nil, err
+	}
+	defer imgMat.Close()
+
+	var faces []FaceDetection
+	for _, rect := range d.Classifier.DetectMultiScaleWithParams(imgMat, 1.1, cascadeMinNeighbours, 0, image.Point{}, image.Point{}) {
+		faces = append(faces, FaceDetection{Rect: rect, Confidence: 1})
+	}
+	return faces, nil
+}
+
+func (d *CascadeFaceDetector) Close() error {
+	return d.Classifier.Close()
+}
+
+// DNNFaceDetector runs an OpenCV DNN face detection model on the CPU, such as the res10 SSD Caffe model.
+type DNNFaceDetector struct {
+	Net           gocv.Net
+	MinConfidence float32
+}
+
+func NewDNNFaceDetector(modelPath, configPath string, minConfidence float32) (*DNNFaceDetector, error) {
+	net := gocv.ReadNet(modelPath, configPath)
+	if net.Empty() {
+		return nil, fmt.Errorf("Error reading network model: %v %v", modelPath, configPath)
+	}
+	err := net.SetPreferableBackend(gocv.NetBackendDefault)
+	if err == nil {
+		err = net.SetPreferableTarget(gocv.NetTargetCPU)
+	}
+	if err != nil {
+		net.Close()
+		return nil, err
+	}
+	return &DNNFaceDetector{
+		Net:           net,
+		MinConfidence: minConfidence,
+	}, nil
+}
+
+func (d *DNNFaceDetector) Detect(img image.Image) ([]FaceDetection, error) {
+	imgMat, err := gocv.ImageToMatRGB(img)
+	if err != nil {
+		return nil, err
+	}
+	defer imgMat.Close()
+
+	blob := gocv.BlobFromImage(imgMat, 1.0, image.Pt(dnnInputSize, dnnInputSize), gocv.NewScalar(104, 177, 123, 0), false, false)
+	defer blob.Close()
+	d.Net.SetInput(blob, "")
+	prob := d.Net.Forward("")
+	defer prob.Close()
+
+	// Each detection is 7 values -- image id, class, confidence, then the left, top, right and bottom edges relative to the image size.
+	width := float32(img.Bounds().Dx())
+	height := float32(img.Bounds().Dy())
+	imgRect := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	var detections []FaceDetection
+	for i := 0; i < prob.Total(); i += 7 {
+		confidence := prob.GetFloatAt(0, i+2)
+		if confidence < d.MinConfidence {
+			continue
+		}
+		rect := image.Rect(
+			int(prob.GetFloatAt(0, i+3)*width),
+			int(prob.GetFloatAt(0, i+4)*height),
+			int(prob.GetFloatAt(0, i+5)*width),
+			int(prob.GetFloatAt(0, i+6)*height),
+		).Intersect(imgRect)
+		if rect.Empty() {
+			continue
+		}
+		detections = append(detections, FaceDetection{Rect: rect, Confidence: confidence})
+	}
+	return detections, nil
+}
+
+func (d *DNNFaceDetector) Close() error {
+	return d.Net.Close()
+}
+
+func facesOverlap(a, b image.Rectangle) bool {
+	if rectOverlap(a, b) > faceMaxOverlap {
+		return true
+	}
+	intersection := a.Intersect(b)
+	if intersection.Empty() {
+		return false
+	}
+	smallerArea := a.Dx() * a.Dy()
+	if bArea := b.Dx() * b.Dy(); bArea < smallerArea {
+		smallerArea = bArea
+	}
+	return float64(intersection.Dx()*intersection.Dy())/float64(smallerArea) > faceMaxContainment
+}
+
+// Group detections around the most confident detection left, along with every other detection that overlaps it -- much like non-maximum suppression.
+// Grouping is not transitive, so two nearby faces that each overlap a detection between them are kept apart.
+func groupOverlappingFaces(detections []FaceDetection) [][]FaceDetection {
+	remaining := make([]FaceDetection, len(detections))
+	copy(remaining, detections)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Confidence > remaining[j].Confidence
+	})
+	var groups [][]FaceDetection
+	for len(remaining) > 0 {
+		best := remaining[0]
+		group := []FaceDetection{best}
+		var rest []FaceDetection
+		for _, detection := range remaining[1:] {
+			if facesOverlap(best.Rect, detection.Rect) {
+				group = append(group, detection)
+			} else {
+				rest = append(rest, detection)
+			}
+		}
+		groups = append(groups, group)
+		remaining = rest
+	}
+	return groups
+}
+
+// Merge a group of detections into the confidence weighted average of their rectangles, keeping the highest confidence.
+func mergeFaceGroup(group []FaceDetection) FaceDetection {
+	var minX, minY, maxX, maxY, totalWeight float64
+	var merged FaceDetection
+	for _, detection := range group {
+		weight := float64(detection.Confidence)
+		if weight <= 0 {
+			weight = 1e-6
+		}
+		minX += float64(detection.Rect.Min.X) * weight
+		minY += float64(detection.Rect.Min.Y) * weight
+		maxX += float64(detection.Rect.Max.X) * weight
+		maxY += float64(detection.Rect.Max.Y) * weight
+		totalWeight += weight
+		if detection.Confidence > merged.Confidence {
+			merged.Confidence = detection.Confidence
+		}
+	}
+	merged.Rect = image.Rect(int(minX/totalWeight+0.5), int(minY/totalWeight+0.5), int(maxX/totalWeight+0.5), int(maxY/totalWeight+0.5))
+	return merged
+}
+
+// Drop faces narrower than minWidth, a fraction of the image width, then merge the overlapping detections that remain.
+// Faces are returned in reading order -- top to bottom, then left to right.
+func filterFaces(detections []FaceDetection, imgBounds image.Rectangle, minWidth float64) []FaceDetection {
+	var wideEnough []FaceDetection
+	for _, detection := range detections {
+		if float64(detection.Rect.Dx()) >= minWidth*float64(imgBounds.Dx()) {
+			wideEnough = append(wideEnough, detection)
+		}
+	}
+	var faces []FaceDetection
+	for _, group := range groupOverlappingFaces(wideEnough) {
+		faces = append(faces, mergeFaceGroup(group))
+	}
+	sort.SliceStable(faces, func(i, j int) bool {
+		if faces[i].Rect.Min.Y != faces[j].Rect.Min.Y {
+			return faces[i].Rect.Min.Y < faces[j].Rect.Min.Y
+		}
+		return faces[i].Rect.Min.X < faces[j].Rect.Min.X
+	})
+	return faces
+}
+
+func faceRects(faces []FaceDetection) []image.Rectangle {
+	rects := make([]image.Rectangle, len(faces))
+	for i, face := range faces {
+		rects[i] = face.Rect
+	}
+	return rects
+}
+
+// Create the face detector for a command from its --face-detector flags.
+func newFaceDetector(cmd *cli.Command) (FaceDetector, error) {
+	detector, _ := cmd.Flags().GetString("face-detector")
+	switch detector {
+	case "cascade":
+		cascadeFile, _ := cmd.Flags().GetString("cascade-file")
+		return NewCascadeFaceDetector(cascadeFile)
+	case "dnn":
+		modelPath, _ := cmd.Flags().GetString("dnn-model")
+		configPath, _ := cmd.Flags().GetString("dnn-config")
+		minConfidence, _ := cmd.Flags().GetFloat32("dnn-min-confidence")
+		if modelPath == "" {
+			return nil, errors.New("--dnn-model is required for the dnn face detector")
+		}
+		return NewDNNFaceDetector(modelPath, configPath, minConfidence)
+	}
+	return nil, fmt.Errorf("Unknown face detector %q - expected cascade or dnn", detector)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFilterFaces(t *testing.T) {
+	imgBounds := image.Rect(0, 0, 1000, 800)
+	detections := []FaceDetection{
+		{Rect: image.Rect(500, 100, 700, 300), Confidence: 0.9},
+		// Overlaps the face above, and is merged into it.
+		{Rect: image.Rect(510, 110, 710, 310), Confidence: 0.9},
+		{Rect: image.Rect(100, 100, 300, 300), Confidence: 0.6},
+		// Too narrow relative to the image.
+		{Rect: image.Rect(100, 500, 150, 550), Confidence: 0.99},
+	}
+	faces := filterFaces(detections, imgBounds, 0.1)
+	if len(faces) != 2 {
+		t.Fatalf("Expected 2 faces, got %d - %v", len(faces), faces)
+	}
+	if faces[0].Rect != image.Rect(100, 100, 300, 300) || faces[0].Confidence != 0.6 {
+		t.Errorf("Expected the left face first, got %v", faces[0])
+	}
+	if faces[1].Rect != image.Rect(505, 105, 705, 305) || faces[1].Confidence != 0.9 {
+		t.Errorf("Expected the overlapping faces to be merged, got %v", faces[1])
+	}
+
+	// The same detections on a screenshot twice the size keep the narrow face out.
+	if faces := filterFaces(detections, image.Rect(0, 0, 2000, 1600), 0.1); len(faces) != 2 {
+		t.Errorf("Expected 2 faces at double the size, got %v", faces)
+	}
+}
+
+func TestGroupOverlappingFacesIsNotTransitive(t *testing.T) {
+	detections := []FaceDetection{
+		{Rect: image.Rect(100, 0, 200, 100), Confidence: 0.8},
+		{Rect: image.Rect(0, 0, 100, 100), Confidence: 0.9},
+		// Overlaps both of the above, but only joins the most confident of them.
+		{Rect: image.Rect(30, 0, 170, 100), Confidence: 0.5},
+	}
+	groups := groupOverlappingFaces(detections)
+	if len(groups) != 2 || len(groups[0]) != 2 || len(groups[1]) != 1 {
+		t.Fatalf("Expected a group of 2 and a group of 1, got %v", groups)
+	}
+	if groups[0][0].Rect != image.Rect(0, 0, 100, 100) || groups[1][0].Rect != image.Rect(100, 0, 200, 100) {
+		t.Errorf("Expected the groups to be around the most confident detections, got %v", groups)
+	}
+}
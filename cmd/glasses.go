@@ -31,6 +31,8 @@ func init() {
 	glassesCmd.PersistentFlags().StringP("output", "o", "./output/step2.1", "Path to local output directory.")
 	glassesCmd.PersistentFlags().StringArrayP("source", "s", []string{}, "Path to source image directories. Can be both enhanced image directory and original step2 directory.")
 	glassesCmd.PersistentFlags().StringP("facedata", "f", "", "Path to AWS Face Analysis dataset directory.")
+	glassesCmd.PersistentFlags().String("enhancements", defaultEnhancementCatalogueFile, "Path to the enhancement catalogue -- the enhancements and types that can be chosen, their probabilities and rules. Images are prepared by the rules of the Glasses enhancement.")
+	glassesCmd.PersistentFlags().String("policy", defaultSafetyPolicyFile, "Path to the safety policy -- the minimum age, exclusion lists and face quality and pose limits of the images that can be selected.")
 	glassesCmd.PersistentFlags().IntP("limit", "l", 1000, "Limit on the number of images prepared.")
 
 	_ = glassesCmd.MarkFlagRequired("source")
@@ -41,6 +43,8 @@ func PrepareGlassesDirectory(cmd *cli.Command, args []string) {
 	sourceDirs, _ := cmd.Flags().GetStringArray("source")
 	facedataDir, _ := cmd.Flags().GetString("facedata")
 	limit, _ := cmd.Flags().GetInt("limit")
+	glasses := catalogueEnhancementFlag(cmd, "Glasses")
+	useSafetyPolicyFlag(cmd)
 
 	log.Println("Start preparing of images for glasses enhancement...")
 
@@ -59,6 +63,7 @@ func PrepareGlassesDirectory(cmd *cli.Command, args []string) {
 
 	// Fetch the facedata details
 	count := 0
+	skipped := map[string]int{}
 	var processed []FaceById
 	var remaining []FaceById
 	for _, facedataPath := range facedataPaths {
@@ -72,21 +77,32 @@ func PrepareGlassesDirectory(cmd *cli.Command, args []string) {
 		// Read the file and unmarshal the data
 		file, _ := ioutil.ReadFile(facedataPath)
 		_ = json.Unmarshal([]byte(file), &facedata)
-
-		faceDetails := facedata.FaceDetails[0]
+		if len(facedata.FaceDetails) == 0 {
+			log.Printf("WARN: No face analysis data in %v\n", facedataPath)
+			continue
+		}
 
 		filename := filepath.Base(facedataPath)
 		extension := filepath.Ext(filename)
 		name := filename[0 : len(filename)-len(extension)]
 
-		if faceDetails.Eyeglasses.Value || faceDetails.Sunglasses.Value {
+		// Only images the safety policy allows are considered by the Glasses rules
+		if reason := safetyPolicy.Check(name, facedata); reason != "" {
+			skipped[reason]++
+			continue
+		}
+		faceDetails := facedata.FaceDetails[0]
+		outcome := glasses.Evaluate(faceDetails)
+
+		// Images the catalogue rules force glasses onto -- the rest of the eligible images are picked from at random
+		if outcome.Eligible && outcome.Forced {
 			// Move the file to the new directory.
 			processed = append(processed, FaceById{
 				Id:   name,
 				Data: facedata,
 			})
 			count++
-		} else if *faceDetails.AgeRange.Low > 5 && *faceDetails.AgeRange.High > 5 {
+		} else if outcome.Eligible {
 			remaining = append(remaining, FaceById{
 				Id:   name,
 				Data: facedata,
@@ -168,5 +184,5 @@ func PrepareGlassesDirectory(cmd *cli.Command, args []string) {
 	jsonFile.Close()
 	log.Println("JSON data written to ", jsonFile.Name())
 
-	log.Printf("%d [Count: %d] images prepared for glasses enhancement!\n", len(processed), count)
+	log.Printf("%d [Count: %d] images prepared for glasses enhancement! Skipped %v by the safety policy\n", len(processed), count, policySkipSummary(skipped))
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"path"
 	"path/filepath"
@@ -96,8 +97,19 @@ func Index(cmd *cli.Command, args []string) {
 		nextToken = *listFacesOutput.NextToken
 	}
 	log.Printf("%d faces found in collection\n", len(listedFaces))
-	// Index the images in the source directory.
-	for _, imagePath := range sourceImagePaths {
+	// Index the images in the source directory. Each image is stored in the collection as soon as it is indexed, so an interrupted index stops after the current image.
+	interruptCtx, stop := withInterrupt(ctx)
+	defer stop()
+	indexedCount, skippedCount := 0, 0
+	for i, imagePath := range sourceImagePaths {
+		if interrupted(interruptCtx) {
+			resume := "Run index again to resume"
+			if overwrite {
+				resume = "Run index again without --overwrite to resume"
+			}
+			notifyInterrupted(fmt.Sprintf("%d images indexed, %d skipped, %d not yet indexed into collection %v. %v", indexedCount, skippedCount, len(sourceImagePaths)-i, collectionId, resume))
+			return
+		}
 		img, _, _ := robotgo.DecodeImg(imagePath)
 		imgBytes, err := ImageToBytes(img)
 		if err != nil {
@@ -117,6 +129,7 @@ func Index(cmd *cli.Command, args []string) {
 			}
 			if shouldSkip {
 				log.Printf("ID: %s skipped\n", name)
+				skippedCount++
 				continue
 			}
 		}
@@ -135,5 +148,6 @@ func Index(cmd *cli.Command, args []string) {
 			log.Printf("WARN: No faces detected: %v - %v", imagePath, string(jsonOutput))
 		}
 		log.Printf("ID: %s - %d faces indexed, %d faces detected but dismissed\n", name, len(output.FaceRecords), len(output.UnindexedFaces))
+		indexedCount++
 	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gen2brain/beeep"
+)
+
+const runCheckpointFile = "checkpoint.json"
+
+// RunCheckpoint records where an interrupted run stopped, and how to pick it up again.
+type RunCheckpoint struct {
+	Command     string    `json:"command"`
+	StoppedAt   time.Time `json:"stoppedAt"`
+	Done        int       `json:"done"`
+	Quarantined int       `json:"quarantined,omitempty"`
+	Resume      string    `json:"resume"`
+}
+
+func (c RunCheckpoint) Save(checkpointPath string) error {
+	checkpointJson, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(checkpointPath, checkpointJson, 0644)
+}
+
+// Cancel a context on SIGINT or SIGTERM, so that a command can stop at the end of its current step and write what it has gathered so far.
+// Once the context is cancelled a second signal kills the process as usual. Call stop once the command is done to stop listening.
+func withInterrupt(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			logger.Step("interrupt").Warnf("Received %v - stopping after the current step. Press Ctrl-C again to exit immediately", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// Whether a context was cancelled by an interrupt, rather than run to completion.
+func interrupted(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// Desktop notification of a command stopped part way through.
+func notifyInterrupted(summary string) {
+	logger.Step("interrupt").Infof("Interrupted - %v", summary)
+	_ = beeep.Notify("Automatically Animated", "Interrupted - "+summary, "")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithInterrupt(t *testing.T) {
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	if interrupted(ctx) {
+		t.Fatal("Expected the context not to be interrupted before a signal")
+	}
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected SIGTERM to interrupt the context")
+	}
+}
+
+func TestScanCheckpoint(t *testing.T) {
+	checkpointPath := path.Join(t.TempDir(), "scan-checkpoint.json")
+	checkpoint := ScanCheckpoint{
+		Source:   "./output/step1",
+		Scanned:  []string{"1", "2"},
+		Similars: [][2]string{{"1", "7"}},
+	}
+	if err := checkpoint.Save(checkpointPath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadScanCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Source != checkpoint.Source || len(loaded.Scanned) != 2 || len(loaded.Similars) != 1 || loaded.Similars[0] != [2]string{"1", "7"} {
+		t.Errorf("Unexpected checkpoint %+v", loaded)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+const (
+	runJournalFile = "journal.jsonl"
+	runSkippedFile = "skipped.json"
+)
+
+// RunJournal appends each enhanced image to a JSONL file in the run's output directory as soon as it is done.
+// Unlike index.json, which is written from memory, the journal survives a crash part way through a run and is used to resume it.
+type RunJournal struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open the journal in a run directory for appending, creating it if it does not exist.
+func OpenRunJournal(runDir string) (*RunJournal, error) {
+	journalPath := path.Join(runDir, runJournalFile)
+	file, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Drop an entry that a crash cut short, so that new entries start on a line of their own.
+	contents, err := ioutil.ReadFile(journalPath)
+	if err == nil && len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		err = file.Truncate(int64(bytes.LastIndexByte(contents, '\n') + 1))
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &RunJournal{
+		Path: journalPath,
+		file: file,
+	}, nil
+}
+
+// Append an enhanced image to the journal, syncing it to disk before returning.
+func (j *RunJournal) Append(indexedImage IndexedImage) error {
+	line, err := json.Marshal(indexedImage)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *RunJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Load the images enhanced in a run from its journal.
+// A crash may cut the last line short, so an unreadable last line is skipped. Any other unreadable line is an error.
+func LoadRunJournal(runDir string) ([]IndexedImage, error) {
+	journalPath := path.Join(runDir, runJournalFile)
+	file, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(file, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	var indexedImages []IndexedImage
+	for i, line := range lines {
+		var indexedImage IndexedImage
+		err := json.Unmarshal(line, &indexedImage)
+		if err != nil {
+			if i == len(lines)-1 {
+				log.Printf("WARN: Skipping incomplete last entry of %v - %v\n", journalPath, err.Error())
+				break
+			}
+			return nil, fmt.Errorf("%v: %s line %d", err, journalPath, i+1)
+		}
+		indexedImages = append(indexedImages, indexedImage)
+	}
+	return indexedImages, nil
+}
+
+// Set up the output directory of an enhancement run and open its journal.
+// A new run gets a directory named after the current timestamp. Resuming a run reuses its directory and loads the images it already enhanced into imageIndex, so they are skipped.
+func openRunOutput(outputParentDir, resumeDir string) (string, *RunJournal, error) {
+	outputDir := resumeDir
+	if resumeDir == "" {
+		outputDir = path.Join(outputParentDir, strconv.FormatInt(currentTs, 10))
+		err := os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		indexedImages, err := LoadRunJournal(resumeDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", nil, fmt.Errorf("Cannot resume %v - it has no %v", resumeDir, runJournalFile)
+			}
+			return "", nil, err
+		}
+		imageIndex = append(imageIndex, indexedImages...)
+		log.Printf("Resuming run %v with %d images already enhanced\n", resumeDir, len(indexedImages))
+	}
+	journal, err := OpenRunJournal(outputDir)
+	if err != nil {
+		return "", nil, err
+	}
+	return outputDir, journal, nil
+}
+
+// Load the images a run skipped. A run that skipped none has no file.
+func LoadRunSkipped(runDir string) ([]SkippedImage, error) {
+	skippedPath := path.Join(runDir, runSkippedFile)
+	file, err := ioutil.ReadFile(skippedPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var skipped []SkippedImage
+	err = json.Unmarshal(file, &skipped)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, skippedPath)
+	}
+	return skipped, nil
+}
+
+func saveRunSkipped(runDir string, skipped []SkippedImage) error {
+	skippedJson, err := json.MarshalIndent(skipped, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(runDir, runSkippedFile), skippedJson, 0644)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRunJournalResume(t *testing.T) {
+	runDir := t.TempDir()
+	journal, err := OpenRunJournal(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2"} {
+		err = journal.Append(IndexedImage{Id: id, Enhancements: []map[string]string{{"name": "Beards", "type": "Lion"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal.Close()
+
+	// Simulate a crash part way through writing the third entry.
+	file, err := os.OpenFile(path.Join(runDir, runJournalFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"id":"3","enhance`)
+	file.Close()
+
+	imageIndex = nil
+	defer func() { imageIndex = nil }()
+	outputDir, journal, err := openRunOutput("", runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputDir != runDir || len(imageIndex) != 2 || imageIndex[1].Id != "2" || imageIndex[1].Enhancements[0]["type"] != "Lion" {
+		t.Fatalf("Unexpected resumed run %v - %+v", outputDir, imageIndex)
+	}
+	err = journal.Append(IndexedImage{Id: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	indexedImages, err := LoadRunJournal(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexedImages) != 3 || indexedImages[2].Id != "3" {
+		t.Errorf("Expected the cut short entry to be replaced, got %+v", indexedImages)
+	}
+}
+
+func TestLoadRunJournalRejectsCorruptEntries(t *testing.T) {
+	runDir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(runDir, runJournalFile), []byte("{\"id\":\"1\"}\nnot json\n{\"id\":\"2\"}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRunJournal(runDir); err == nil {
+		t.Error("Expected a corrupt entry before the last to be an error")
+	}
+	if _, _, err := openRunOutput("", t.TempDir()); err == nil {
+		t.Error("Expected resuming a directory without a journal to be an error")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cli "github.com/spf13/cobra"
+)
+
+const runLogFile = "log.jsonl"
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level %v", name)
+}
+
+// LogEntry is a line of the JSON log of a run.
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	RunId   string                 `json:"runId"`
+	Command string                 `json:"command,omitempty"`
+	ImageId string                 `json:"imageId,omitempty"`
+	Step    string                 `json:"step,omitempty"`
+	Message string                 `json:"msg"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// RunLog writes log entries as readable lines to the console, and as JSON lines to the log file of the run once one is opened.
+// Entries below Level are dropped.
+type RunLog struct {
+	RunId   string
+	Command string
+	Level   LogLevel
+
+	mu      sync.Mutex
+	console io.Writer
+	file    *os.File
+}
+
+// The log of the current command. Lines written with the standard log package are written to it too.
+var runLog = &RunLog{
+	RunId:   strconv.FormatInt(currentTs, 10),
+	Level:   LevelInfo,
+	console: os.Stderr,
+}
+
+func init() {
+	log.SetFlags(0)
+	log.SetOutput(runLog)
+}
+
+// Set up the run log of a command from the root flags. The --debug flag lowers the level to debug.
+func startRunLog(cmd *cli.Command) error {
+	levelName, _ := cmd.Flags().GetString("log-level")
+	logPath, _ := cmd.Flags().GetString("log-file")
+	debug, _ := cmd.Flags().GetBool("debug")
+	level, err := parseLogLevel(levelName)
+	if err != nil {
+		return err
+	}
+	if debug && level > LevelDebug {
+		level = LevelDebug
+	}
+	runLog.Command = cmd.CommandPath()
+	runLog.Level = level
+	if logPath != "" {
+		return runLog.OpenFile(logPath)
+	}
+	return nil
+}
+
+// Append the JSON lines of the log to a file. A file that is already open is kept, so that --log-file wins over the run directory.
+func (r *RunLog) OpenFile(logPath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	return nil
+}
+
+func (r *RunLog) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RunLog) write(level LogLevel, entry LogEntry) {
+	if level < r.Level {
+		return
+	}
+	entry.Level = level.String()
+	entry.RunId = r.RunId
+	entry.Command = r.Command
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintln(r.console, consoleLine(entry))
+	if r.file != nil {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, _ = r.file.Write(append(line, '\n'))
+		}
+	}
+}
+
+// A readable line of an entry, eg. 2021/12/06 10:04:05 WARN [Face 42] [save] Failed to Save attempts=5
+func consoleLine(entry LogEntry) string {
+	var b strings.Builder
+	b.WriteString(entry.Time.Format("2006/01/02 15:04:05 "))
+	if entry.Level != LevelInfo.String() {
+		b.WriteString(entry.Level + " ")
+	}
+	if entry.ImageId != "" {
+		fmt.Fprintf(&b, "[Face %v] ", entry.ImageId)
+	}
+	if entry.Step != "" {
+		fmt.Fprintf(&b, "[%v] ", entry.Step)
+	}
+	b.WriteString(entry.Message)
+	keys := make([]string, 0, len(entry.Fields))
+	for key := range entry.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %v=%v", key, entry.Fields[key])
+	}
+	return b.String()
+}
+
+var (
+	logLevelPrefix = regexp.MustCompile(`^(DEBUG|WARN|ERROR):?\s*`)
+	logFacePrefix  = regexp.MustCompile(`^\[(?:Index \S+ )?Face ([^\]]*)\]\s*`)
+)
+
+// Write a line of the standard log package. Lines are still written with the WARN:, ERROR: and DEBUG: prefixes and the face of SourcedFace.String in places, so these are taken as the level and image id.
+func (r *RunLog) Write(p []byte) (int, error) {
+	entry := LogEntry{Time: time.Now()}
+	level := LevelInfo
+	message := strings.TrimSpace(string(p))
+	for i := 0; i < 2; i++ {
+		if match := logLevelPrefix.FindStringSubmatch(message); match != nil {
+			level, _ = parseLogLevel(match[1])
+			message = message[len(match[0]):]
+		}
+		if match := logFacePrefix.FindStringSubmatch(message); match != nil {
+			entry.ImageId = match[1]
+			message = message[len(match[0]):]
+		}
+	}
+	entry.Message = message
+	r.write(level, entry)
+	return len(p), nil
+}
+
+// Logger writes entries to the run log with the context of what is being logged -- the image and the step of the pipeline it is at, along with any other fields.
+// Loggers are values, so each With returns a new logger and leaves the one it was called on unchanged.
+type Logger struct {
+	imageId string
+	step    string
+	fields  map[string]interface{}
+}
+
+// The logger of the command, without any context.
+var logger = Logger{}
+
+func (l Logger) Image(imageId string) Logger {
+	l.imageId = imageId
+	return l
+}
+
+func (l Logger) Face(face SourcedFace) Logger {
+	return l.Image(face.ImageId).With("index", face.Index)
+}
+
+func (l Logger) Step(step string) Logger {
+	l.step = step
+	return l
+}
+
+func (l Logger) With(key string, value interface{}) Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	l.fields = fields
+	return l
+}
+
+func (l Logger) log(level LogLevel, format string, args ...interface{}) {
+	runLog.write(level, LogEntry{
+		Time:    time.Now(),
+		ImageId: l.imageId,
+		Step:    l.step,
+		Message: fmt.Sprintf(format, args...),
+		Fields:  l.fields,
+	})
+}
+
+func (l Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
+}
+
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
+}
+
+func (l Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
+}
+
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+}
+
+// Log an error, then exit once the log file is closed.
+func (l Logger) Fatalf(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+	_ = runLog.Close()
+	os.Exit(1)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRunLog(t *testing.T) {
+	var console bytes.Buffer
+	previous := runLog
+	runLog = &RunLog{RunId: "1638706561", Command: "npc enhance-v2", Level: LevelInfo, console: &console}
+	defer func() { runLog = previous }()
+	logPath := path.Join(t.TempDir(), runLogFile)
+	if err := runLog.OpenFile(logPath); err != nil {
+		t.Fatal(err)
+	}
+
+	face := logger.Face(SourcedFace{Index: 3, ImageId: "42"})
+	face.Step("save").With("attempts", 5).Warnf("Failed to Save")
+	face.Step("apply").Debugf("Dropped below the level")
+	_, _ = runLog.Write([]byte("ERROR: [Index 4 Face 43] Cannot find Apply text/button\n"))
+	_, _ = runLog.Write([]byte("[Index 5 Face 44] WARN: Cannot open image\n"))
+	if err := runLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries above the level, got %+v", entries)
+	}
+	first := entries[0]
+	if first.Level != "WARN" || first.RunId != "1638706561" || first.Command != "npc enhance-v2" || first.ImageId != "42" || first.Step != "save" || first.Message != "Failed to Save" || first.Fields["attempts"] != float64(5) {
+		t.Errorf("Unexpected entry %+v", first)
+	}
+	if entries[1].Level != "ERROR" || entries[1].ImageId != "43" || entries[1].Message != "Cannot find Apply text/button" {
+		t.Errorf("Expected the level and face of a standard log line, got %+v", entries[1])
+	}
+	if entries[2].Level != "WARN" || entries[2].ImageId != "44" || entries[2].Message != "Cannot open image" {
+		t.Errorf("Expected the face before the level of a standard log line, got %+v", entries[2])
+	}
+	if !strings.Contains(console.String(), "WARN [Face 42] [save] Failed to Save attempts=5 index=3") {
+		t.Errorf("Unexpected console output %q", console.String())
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	if level, err := parseLogLevel("warn"); err != nil || level != LevelWarn {
+		t.Errorf("Expected warn, got %v - %v", level, err)
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be an error")
+	}
+}
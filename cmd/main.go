@@ -11,7 +11,6 @@
 package main
 
 import (
-	"log"
 	"time"
 
 	cli "github.com/spf13/cobra"
@@ -19,19 +18,27 @@ import (
 
 var (
 	// The Root Cli Handler
-	rootCmd   = &cli.Command{}
+	rootCmd = &cli.Command{
+		PersistentPreRunE: func(cmd *cli.Command, args []string) error {
+			return startRunLog(cmd)
+		},
+	}
 	currentTs = time.Now().Unix()
 	debugMode = false
 )
 
 func init() {
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "Run the enhancement in debug mode. Will output images to tmp folder.")
+	rootCmd.PersistentFlags().String("log-level", "info", "Lowest level of the log lines to write -- debug, info, warn or error. Debug mode logs at debug.")
+	rootCmd.PersistentFlags().String("log-file", "", "Path to a file to append the log to as JSON lines. Enhancement runs log to log.jsonl in the run's output directory when not set.")
 
 }
 
 func main() {
 	// Run the program
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatalln("ERROR:", err)
+	err := rootCmd.Execute()
+	if err != nil {
+		logger.Fatalf("%v", err)
 	}
+	_ = runLog.Close()
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+)
+
+type ScreenState string
+
+const (
+	StateUnknown          ScreenState = "unknown"
+	StateBlueStacksHome   ScreenState = "bluestacks-home"
+	StateMediaManager     ScreenState = "media-manager"
+	StateHome             ScreenState = "home"
+	StateGallery          ScreenState = "gallery"
+	StateFolderPicker     ScreenState = "folder-picker"
+	StateEditor           ScreenState = "editor"
+	StateEnhancementStrip ScreenState = "enhancement-strip"
+	StateSave             ScreenState = "save"
+	StateExitModal        ScreenState = "exit-modal"
+	StateThrottled        ScreenState = "throttled"
+
+	// A marker must be found with at least this confidence to name the screen.
+	screenStateMinConfidence = 0.8
+	// Navigation gives up once this many actions have not reached the target.
+	navigationMaxSteps = 12
+)
+
+// ScreenStateDefinition names a screen. The screen is identified by the marker elements of its state in the asset manifest, any one of which is enough.
+// Overlays are drawn over another screen, so they come before the screen underneath in order of precedence.
+type ScreenStateDefinition struct {
+	State   ScreenState
+	Overlay bool
+}
+
+// Screen states in order of precedence -- the first state with a marker on screen names it.
+// The save screen is the before and after layout shown once a face is saved, and the throttled screen is FaceApp's error or try again later dialog.
+// Both are named by markers captured from FaceApp into the asset pack -- a pack without them backs out of the save screen like any other unknown screen, and does not detect throttling.
+var screenStates = []ScreenStateDefinition{
+	{State: StateThrottled, Overlay: true},
+	{State: StateExitModal, Overlay: true},
+	{State: StateFolderPicker, Overlay: true},
+	{State: StateSave},
+	{State: StateEnhancementStrip},
+	{State: StateEditor},
+	{State: StateGallery},
+	{State: StateHome},
+	{State: StateMediaManager},
+	{State: StateBlueStacksHome},
+}
+
+// NavigationEdge is a single action that moves from one screen to another.
+// The screen reached is checked again after each action, so an edge that lands somewhere else -- such as the exit modal -- is planned around.
+// Templates are the elements the action locates, so that a dry run can list them.
+type NavigationEdge struct {
+	From      ScreenState
+	To        ScreenState
+	Navigate  func(b *BlueStacks, screenImg image.Image) error
+	Templates []string
+}
+
+var navigationGraph = []NavigationEdge{
+	{From: StateBlueStacksHome, To: StateHome, Navigate: clickElement("faceapp-app-control", "faceapp-tab", 1000), Templates: []string{"faceapp-app-control"}}, // In case there is a Splash Screen
+	{From: StateBlueStacksHome, To: StateMediaManager, Navigate: clickElement("media-manager-app-control", "media-manager-app", 500), Templates: []string{"media-manager-app-control"}},
+	{From: StateMediaManager, To: StateBlueStacksHome, Navigate: closeMediaManager, Templates: []string{"media-manager-tab-control"}},
+	{From: StateHome, To: StateGallery, Navigate: clickElement("gallery", "gallery", 1000), Templates: []string{"gallery"}},
+	{From: StateHome, To: StateBlueStacksHome, Navigate: navigateBack},
+	{From: StateGallery, To: StateFolderPicker, Navigate: clickElement("folder-filter", "filterFolder", 1000), Templates: []string{"folder-filter"}},
+	{From: StateGallery, To: StateHome, Navigate: navigateBack},
+	{From: StateFolderPicker, To: StateGallery, Navigate: selectSharedFolder},
+	{From: StateEditor, To: StateGallery, Navigate: navigateBack},
+	{From: StateEnhancementStrip, To: StateEditor, Navigate: navigateBack},
+	{From: StateSave, To: StateEditor, Navigate: navigateBack},
+	{From: StateExitModal, To: StateGallery, Navigate: clickElement("exit", "exit", 1000), Templates: []string{"exit"}},
+	{From: StateThrottled, To: StateGallery, Navigate: navigateBack}, // Dismiss the dialog
+}
+
+func clickElement(name string, cacheKey string, wait int) func(b *BlueStacks, screenImg image.Image) error {
+	return func(b *BlueStacks, screenImg image.Image) error {
+		coords, err := b.LocateWithCache(name, screenImg, cacheKey)
+		if err != nil {
+			return err
+		}
+		b.MoveClick(coords.X, coords.Y)
+		b.Driver.MilliSleep(wait) // Wait for animation to finish
+		return nil
+	}
+}
+
+func navigateBack(b *BlueStacks, screenImg image.Image) error {
+	err := b.OsBackClick()
+	b.Driver.MilliSleep(1000)
+	return err
+}
+
+func closeMediaManager(b *BlueStacks, screenImg image.Image) error {
+	err := b.CloseMediaManager()
+	b.Driver.MilliSleep(500)
+	return err
+}
+
+func selectSharedFolder(b *BlueStacks, screenImg image.Image) error {
+	//* Opting for a Hotkey approach to minimise room for error
+	b.Driver.KeyTap("down")
+	// b.Driver.KeyTap("down") // Leave one out ... as the new FaceApp orders SharedFolder before FaceApp folder.
+	b.Driver.KeyTap("down")
+	b.Driver.KeyTap("enter")
+	b.Driver.KeyTap("tab")
+	b.Driver.MilliSleep(1000) // Wait for the shared folder gallery to actually load
+	return nil
+}
+
+// Find the shortest sequence of edges from one state to another.
+func planNavigation(edges []NavigationEdge, from, to ScreenState) ([]NavigationEdge, error) {
+	if from == to {
+		return nil, nil
+	}
+	previous := map[ScreenState]NavigationEdge{}
+	visited := map[ScreenState]bool{from: true}
+	queue := []ScreenState{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, edge := range edges {
+			if edge.From != state || visited[edge.To] {
+				continue
+			}
+			visited[edge.To] = true
+			previous[edge.To] = edge
+			if edge.To == to {
+				var route []NavigationEdge
+				for s := to; s != from; s = previous[s].From {
+					route = append([]NavigationEdge{previous[s]}, route...)
+				}
+				return route, nil
+			}
+			queue = append(queue, edge.To)
+		}
+	}
+	return nil, fmt.Errorf("No route from %v to %v", from, to)
+}
+
+// The elements located on the way from a screen through each of the waypoints -- the markers that name each screen on the route, and the elements that each action locates.
+func routeTemplates(manifest *AssetManifest, from ScreenState, waypoints ...ScreenState) ([]string, error) {
+	var templates []string
+	addMarkers := func(state ScreenState) {
+		for _, element := range manifest.Markers(state) {
+			templates = appendUnique(templates, element.Name)
+		}
+	}
+	addMarkers(from)
+	for _, waypoint := range waypoints {
+		route, err := planNavigation(navigationGraph, from, waypoint)
+		if err != nil {
+			return templates, err
+		}
+		for _, edge := range route {
+			for _, template := range edge.Templates {
+				templates = appendUnique(templates, template)
+			}
+			addMarkers(edge.To)
+		}
+		from = waypoint
+	}
+	return templates, nil
+}
+
+func screenStateCacheKey(element *AssetElement) string {
+	return "state-" + element.Name
+}
+
+// Name the screen shown in a screenshot.
+// States are checked in order of precedence, as some screens show the markers of others too -- the enhancement strip still shows the editor's save button.
+// Markers that were found before are only re-checked in a small region around their cached coordinates, which is much quicker than searching the whole screen, and markers with a region in the manifest are only searched for within it.
+// The whole screen is searched for markers that have not been found yet -- and for markers that were found elsewhere before, only once no other marker names the screen.
+func (b *BlueStacks) ClassifyScreen(screenImg image.Image) ScreenState {
+	manifest := b.assets()
+	cache := b.coordsCache()
+	findMarker := func(element *AssetElement) bool {
+		coords, imagePath, _, err := b.locate(element.Name, screenImg, screenStateMinConfidence)
+		if err != nil {
+			return false
+		}
+		cache.Set(screenStateCacheKey(element), CoordsCacheEntry{Coords: coords, Template: imagePath})
+		return true
+	}
+
+	var moved []*AssetElement
+	for _, definition := range screenStates {
+		for _, element := range manifest.Markers(definition.State) {
+			if entry, found := cache.Get(screenStateCacheKey(element)); found {
+				if b.validateCachedCoords(entry, screenImg) == nil {
+					return definition.State
+				}
+				moved = append(moved, element)
+				continue
+			}
+			if findMarker(element) {
+				return definition.State
+			}
+		}
+	}
+	for _, element := range moved {
+		if findMarker(element) {
+			return element.State
+		}
+	}
+	return StateUnknown
+}
+
+// Drive the app from whatever screen is shown to the target screen.
+// Unrecognised screens are given a moment to finish animating, then backed out of.
+func (b *BlueStacks) NavigateTo(target ScreenState) error {
+	unknownCount := 0
+	for step := 0; step < navigationMaxSteps; step++ {
+		screenImg := b.Driver.CaptureImg()
+		state := b.ClassifyScreen(screenImg)
+		if state == target {
+			return nil
+		}
+		if state == StateUnknown {
+			unknownCount++
+			if unknownCount > 1 {
+				log.Printf("WARN: Cannot recognise the current screen on the way to %v - backing out\n", target)
+				_ = b.OsBackClick()
+			}
+			b.Driver.MilliSleep(1000)
+			continue
+		}
+		unknownCount = 0
+		route, err := planNavigation(navigationGraph, state, target)
+		if err != nil {
+			return err
+		}
+		if debugMode {
+			log.Printf("DEBUG: Navigating from %v to %v on the way to %v\n", state, route[0].To, target)
+		}
+		err = route[0].Navigate(b, screenImg)
+		if err != nil {
+			return fmt.Errorf("Cannot navigate from %v to %v - %v", state, route[0].To, err.Error())
+		}
+	}
+	return fmt.Errorf("Cannot reach %v within %d steps", target, navigationMaxSteps)
+}
+
+// Navigate through each of the waypoints in turn.
+func (b *BlueStacks) FollowRoute(waypoints ...ScreenState) error {
+	if len(waypoints) == 0 {
+		return errors.New("Route has no waypoints")
+	}
+	for _, waypoint := range waypoints {
+		err := b.NavigateTo(waypoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestPlanNavigation(t *testing.T) {
+	route, err := planNavigation(navigationGraph, StateEnhancementStrip, StateBlueStacksHome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ScreenState{StateEditor, StateGallery, StateHome, StateBlueStacksHome}
+	if len(route) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d - %v", len(expected), len(route), route)
+	}
+	for i, edge := range route {
+		if edge.To != expected[i] {
+			t.Errorf("Step %d - expected %v, got %v", i, expected[i], edge.To)
+		}
+	}
+
+	route, err = planNavigation(navigationGraph, StateSave, StateHome)
+	if err != nil || len(route) != 3 || route[0].To != StateEditor {
+		t.Errorf("Expected to back out of the save screen to the editor on the way home, got %v - %v", route, err)
+	}
+
+	if route, err := planNavigation(navigationGraph, StateGallery, StateGallery); err != nil || len(route) != 0 {
+		t.Errorf("Expected no steps to stay on the same screen, got %v - %v", route, err)
+	}
+	if _, err := planNavigation(navigationGraph, StateBlueStacksHome, StateEditor); err == nil {
+		t.Error("Expected the editor to be unreachable without opening a face")
+	}
+}
+
+func TestNavigationGraphReachesEveryState(t *testing.T) {
+	for _, from := range screenStates {
+		for _, to := range []ScreenState{StateBlueStacksHome, StateGallery} {
+			if _, err := planNavigation(navigationGraph, from.State, to); err != nil {
+				t.Errorf("Expected a route from %v to %v - %v", from.State, to, err)
+			}
+		}
+	}
+}
+
+func TestRouteTemplates(t *testing.T) {
+	manifest := loadTestAssetManifest(t)
+	templates, err := routeTemplates(manifest, StateHome, StateGallery, StateFolderPicker, StateGallery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The home and gallery markers, the folder filter that is clicked and is the gallery marker, and the folder picker marker
+	expected := []string{"gallery", "folder-filter", "sharedfolder"}
+	if len(templates) != len(expected) {
+		t.Fatalf("Expected templates %v, got %v", expected, templates)
+	}
+	for i, template := range templates {
+		if template != expected[i] {
+			t.Errorf("Template %d - expected %v, got %v", i, expected[i], template)
+		}
+	}
+
+	if _, err := routeTemplates(manifest, StateBlueStacksHome, StateEditor); err == nil {
+		t.Error("Expected the templates of an unreachable route to be an error")
+	}
+}
+
+func TestClassifyScreen(t *testing.T) {
+	saveMarker := noiseImage(1, 20, 15)
+	editorMarker := noiseImage(2, 20, 15)
+	manifest := writeTestAssetPack(t, map[string]image.Image{
+		"save-screen.png":   saveMarker,
+		"editor-header.png": editorMarker,
+	}, `{"elements": [
+		{"name": "save-screen", "images": [{"file": "save-screen.png"}], "region": {"left": 0, "top": 0, "width": 0.5, "height": 0.5}, "state": "save", "marker": true},
+		{"name": "editor-header", "images": [{"file": "editor-header.png"}], "state": "editor", "marker": true}
+	]}`)
+	saveScreen, editorScreen, movedEditorScreen := noiseImage(10, 200, 150), noiseImage(11, 200, 150), noiseImage(12, 200, 150)
+	drawImage(saveScreen, saveMarker, image.Pt(20, 20))
+	drawImage(editorScreen, editorMarker, image.Pt(100, 60))
+	drawImage(movedEditorScreen, editorMarker, image.Pt(20, 100))
+
+	driver := &MemoryDriver{Width: 200, Height: 150, Screens: []image.Image{saveScreen, editorScreen}}
+	bluestacks := NewBlueStacks(driver)
+	bluestacks.Assets = manifest
+
+	for _, c := range []struct {
+		screen   image.Image
+		expected ScreenState
+	}{
+		{saveScreen, StateSave},
+		{editorScreen, StateEditor},
+		{editorScreen, StateEditor},      // From the cached marker
+		{movedEditorScreen, StateEditor}, // From the whole screen, once the cached marker is not found
+		{noiseImage(13, 200, 150), StateUnknown},
+	} {
+		if state := bluestacks.ClassifyScreen(c.screen); state != c.expected {
+			t.Errorf("Expected screen %v, got %v", c.expected, state)
+		}
+	}
+	if coords, _ := bluestacks.CachedCoords("state-editor-header"); coords.Y < 100 {
+		t.Errorf("Expected the moved marker to be cached again, got %v", coords)
+	}
+
+	if err := bluestacks.NavigateTo(StateEditor); err != nil {
+		t.Fatal(err)
+	}
+	if countActions(driver, ActionKey) != 1 {
+		t.Errorf("Expected to back out of the save screen to the editor, got %v", driver.Actions)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+	cli "github.com/spf13/cobra"
+)
+
+const defaultSafetyPolicyFile = "./assets/policy.json"
+
+// Reason codes of images that are not selected or enhanced. They are written to plans, dry runs and skipped.json, so they are kept stable.
+const (
+	PolicySkipNoFacedata    = "no-facedata"
+	PolicySkipExcluded      = "excluded"
+	PolicySkipMultipleFaces = "multiple-faces"
+	PolicySkipUnderage      = "underage"
+	PolicySkipAgeUncertain  = "age-uncertain"
+	PolicySkipBlurry        = "blurry"
+	PolicySkipDark          = "dark"
+	PolicySkipPose          = "pose"
+)
+
+// PolicyAge is the youngest a character can be to be selected.
+type PolicyAge struct {
+	// The low end of the age range must be at least this.
+	Min int32 `json:"min"`
+	// Rekognition gives no confidence of the age range itself, so the age is only trusted on faces detected with at least this confidence, out of 100.
+	MinConfidence float32 `json:"minConfidence,omitempty"`
+}
+
+// PolicyQuality is the least Rekognition image quality of a face, out of 100. Zero is no minimum.
+type PolicyQuality struct {
+	MinSharpness  float32 `json:"minSharpness,omitempty"`
+	MinBrightness float32 `json:"minBrightness,omitempty"`
+}
+
+// PolicyPose is the most a face can be turned away from the camera, in degrees either way. Zero is no maximum.
+type PolicyPose struct {
+	MaxYaw   float32 `json:"maxYaw,omitempty"`
+	MaxPitch float32 `json:"maxPitch,omitempty"`
+	MaxRoll  float32 `json:"maxRoll,omitempty"`
+}
+
+// SafetyPolicy decides which character images can be selected or enhanced at all, before any enhancement rules are considered.
+// It is loaded from a file such as assets/policy.json, and applied by every command that selects or enhances images.
+type SafetyPolicy struct {
+	Age PolicyAge `json:"age"`
+	// Images with more faces detected than this are skipped, as it cannot be told which face is the character. Zero is no maximum.
+	MaxFaces int           `json:"maxFaces,omitempty"`
+	Quality  PolicyQuality `json:"quality,omitempty"`
+	Pose     PolicyPose    `json:"pose,omitempty"`
+	// Paths of lists of image ids that must not be touched, such as hidden-tokens.json and tokens-in-admin.json.
+	Exclude []string `json:"exclude,omitempty"`
+
+	excluded map[string]bool
+}
+
+// The policy of the command. Until a command loads one with --policy, characters are only required to be of age.
+var safetyPolicy = &SafetyPolicy{Age: PolicyAge{Min: enhanceMinAge}}
+
+// Load and validate a policy file, along with the exclusion lists it names.
+func LoadSafetyPolicy(policyPath string) (*SafetyPolicy, error) {
+	file, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	policy := &SafetyPolicy{}
+	err = json.Unmarshal(file, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, policyPath)
+	}
+	err = policy.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, policyPath)
+	}
+	policy.excluded = map[string]bool{}
+	for _, listPath := range policy.Exclude {
+		imageIds, err := loadExclusionList(listPath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load exclusion list - %v", err)
+		}
+		for _, imageId := range imageIds {
+			policy.excluded[imageId] = true
+		}
+	}
+	return policy, nil
+}
+
+func (p *SafetyPolicy) validate() error {
+	if p.Age.Min < 0 {
+		return errors.New("Policy minimum age cannot be negative")
+	}
+	if p.MaxFaces < 0 {
+		return errors.New("Policy maximum faces cannot be negative")
+	}
+	for _, limit := range []float32{p.Age.MinConfidence, p.Quality.MinSharpness, p.Quality.MinBrightness} {
+		if limit < 0 || limit > 100 {
+			return errors.New("Policy confidence and quality minimums must be between 0 and 100")
+		}
+	}
+	for _, limit := range []float32{p.Pose.MaxYaw, p.Pose.MaxPitch, p.Pose.MaxRoll} {
+		if limit < 0 || limit > 180 {
+			return errors.New("Policy pose maximums must be between 0 and 180 degrees")
+		}
+	}
+	return nil
+}
+
+// Load the image ids of an exclusion list. Lists are JSON arrays of ids, or of objects with an id, such as hidden-tokens.json.
+func loadExclusionList(listPath string) ([]string, error) {
+	file, err := ioutil.ReadFile(listPath)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(file))
+	decoder.UseNumber() // Keep ids as written, rather than as floats
+	var entries []interface{}
+	err = decoder.Decode(&entries)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, listPath)
+	}
+	var imageIds []string
+	for _, entry := range entries {
+		if object, ok := entry.(map[string]interface{}); ok {
+			entry = object["id"]
+		}
+		switch id := entry.(type) {
+		case json.Number:
+			imageIds = append(imageIds, id.String())
+		case string:
+			imageIds = append(imageIds, id)
+		default:
+			return nil, fmt.Errorf("Exclusion list entry %v has no id: %s", entry, listPath)
+		}
+	}
+	return imageIds, nil
+}
+
+// The reason code an image is skipped for, or an empty string when it can be selected.
+// The character is the first face of the facedata -- the face every enhancement is chosen by.
+func (p *SafetyPolicy) Check(imageId string, facedata FaceData) string {
+	if p.excluded[imageId] {
+		return PolicySkipExcluded
+	}
+	if len(facedata.FaceDetails) == 0 {
+		return PolicySkipNoFacedata
+	}
+	if p.MaxFaces > 0 && len(facedata.FaceDetails) > p.MaxFaces {
+		return PolicySkipMultipleFaces
+	}
+	face := facedata.FaceDetails[0]
+	if face.AgeRange == nil || face.AgeRange.Low == nil || *face.AgeRange.Low < p.Age.Min {
+		return PolicySkipUnderage
+	}
+	if below(face.Confidence, p.Age.MinConfidence) {
+		return PolicySkipAgeUncertain
+	}
+	quality := face.Quality
+	if quality == nil {
+		quality = &types.ImageQuality{}
+	}
+	if below(quality.Sharpness, p.Quality.MinSharpness) {
+		return PolicySkipBlurry
+	}
+	if below(quality.Brightness, p.Quality.MinBrightness) {
+		return PolicySkipDark
+	}
+	pose := face.Pose
+	if pose == nil {
+		pose = &types.Pose{}
+	}
+	if beyond(pose.Yaw, p.Pose.MaxYaw) || beyond(pose.Pitch, p.Pose.MaxPitch) || beyond(pose.Roll, p.Pose.MaxRoll) {
+		return PolicySkipPose
+	}
+	return ""
+}
+
+// Whether a value is below a minimum. A value that was not detected is below any minimum that is set.
+func below(value *float32, min float32) bool {
+	return min > 0 && (value == nil || *value < min)
+}
+
+// Whether an angle is beyond a maximum either way. An angle that was not detected is beyond any maximum that is set.
+func beyond(angle *float32, max float32) bool {
+	return max > 0 && (angle == nil || math.Abs(float64(*angle)) > float64(max))
+}
+
+// A count of images skipped by each reason code, eg. "2 excluded, 5 underage".
+func policySkipSummary(skipped map[string]int) string {
+	if len(skipped) == 0 {
+		return "no images"
+	}
+	reasons := make([]string, 0, len(skipped))
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%d %v", skipped[reason], reason)
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// Load the policy of the --policy flag into use.
+func useSafetyPolicyFlag(cmd *cli.Command) *SafetyPolicy {
+	policyPath, _ := cmd.Flags().GetString("policy")
+	policy, err := LoadSafetyPolicy(policyPath)
+	if err != nil {
+		logger.Step("setup").Fatalf("Cannot load safety policy - %v", err.Error())
+	}
+	safetyPolicy = policy
+	logger.Step("setup").Infof("Using safety policy %v - %d images excluded", policyPath, len(policy.excluded))
+	return policy
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+func TestSafetyPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+	for name, list := range map[string]string{
+		"hidden-tokens.json":   `[{"id": 1339, "name": "Lederhosens"}]`,
+		"tokens-in-admin.json": `[1702, "1846"]`,
+	} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(list), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policyPath := path.Join(dir, "policy.json")
+	policyJson := `{
+		"age": {"min": 16, "minConfidence": 90},
+		"maxFaces": 1,
+		"quality": {"minSharpness": 10, "minBrightness": 20},
+		"pose": {"maxYaw": 45},
+		"exclude": ["` + path.Join(dir, "hidden-tokens.json") + `", "` + path.Join(dir, "tokens-in-admin.json") + `"]
+	}`
+	if err := ioutil.WriteFile(policyPath, []byte(policyJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadSafetyPolicy(policyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	face := func(low int32, confidence, sharpness, brightness, yaw float32) types.FaceDetail {
+		return types.FaceDetail{
+			AgeRange:   &types.AgeRange{Low: &low},
+			Confidence: &confidence,
+			Quality:    &types.ImageQuality{Sharpness: &sharpness, Brightness: &brightness},
+			Pose:       &types.Pose{Yaw: &yaw},
+		}
+	}
+	allowed := face(21, 99, 50, 50, -10)
+	for _, c := range []struct {
+		ImageId  string
+		Facedata FaceData
+		Expected string
+	}{
+		{"1", FaceData{FaceDetails: []types.FaceDetail{allowed}}, ""},
+		{"1339", FaceData{FaceDetails: []types.FaceDetail{allowed}}, PolicySkipExcluded},
+		{"1702", FaceData{FaceDetails: []types.FaceDetail{allowed}}, PolicySkipExcluded},
+		{"1846", FaceData{}, PolicySkipExcluded},
+		{"2", FaceData{}, PolicySkipNoFacedata},
+		{"3", FaceData{FaceDetails: []types.FaceDetail{allowed, allowed}}, PolicySkipMultipleFaces},
+		{"4", FaceData{FaceDetails: []types.FaceDetail{face(12, 99, 50, 50, 0)}}, PolicySkipUnderage},
+		{"5", FaceData{FaceDetails: []types.FaceDetail{{Confidence: allowed.Confidence}}}, PolicySkipUnderage},
+		{"6", FaceData{FaceDetails: []types.FaceDetail{face(21, 80, 50, 50, 0)}}, PolicySkipAgeUncertain},
+		{"7", FaceData{FaceDetails: []types.FaceDetail{face(21, 99, 5, 50, 0)}}, PolicySkipBlurry},
+		{"8", FaceData{FaceDetails: []types.FaceDetail{face(21, 99, 50, 10, 0)}}, PolicySkipDark},
+		{"9", FaceData{FaceDetails: []types.FaceDetail{face(21, 99, 50, 50, -60)}}, PolicySkipPose},
+	} {
+		if reason := policy.Check(c.ImageId, c.Facedata); reason != c.Expected {
+			t.Errorf("Expected image %v to be skipped for %q, got %q", c.ImageId, c.Expected, reason)
+		}
+	}
+
+	skipped := map[string]int{PolicySkipUnderage: 5, PolicySkipExcluded: 2}
+	if summary := policySkipSummary(skipped); summary != "2 excluded, 5 underage" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestSafetyPolicyValidation(t *testing.T) {
+	dir := t.TempDir()
+	for name, policyJson := range map[string]string{
+		"negative age":     `{"age": {"min": -1}}`,
+		"confidence":       `{"age": {"min": 16, "minConfidence": 120}}`,
+		"pose":             `{"age": {"min": 16}, "pose": {"maxRoll": 200}}`,
+		"missing list":     `{"age": {"min": 16}, "exclude": ["` + path.Join(dir, "missing.json") + `"]}`,
+		"list without ids": `{"age": {"min": 16}, "exclude": ["` + path.Join(dir, "policy.json") + `"]}`,
+	} {
+		policyPath := path.Join(dir, "policy.json")
+		if err := ioutil.WriteFile(policyPath, []byte(policyJson), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSafetyPolicy(policyPath); err == nil {
+			t.Errorf("Expected a policy with a bad %v to be an error", name)
+		}
+	}
+}
+
+func TestDefaultSafetyPolicy(t *testing.T) {
+	// Exclusion lists are relative to the root of the repository, where commands are run from
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	policy, err := LoadSafetyPolicy(defaultSafetyPolicyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Age.Min != enhanceMinAge || !policy.excluded["1339"] || !policy.excluded["1702"] {
+		t.Errorf("Unexpected default policy %+v", policy)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Images are imported at most defaultImportRate times every defaultImportRatePer unless set on the command line.
+	defaultImportRate    = 10
+	defaultImportRatePer = 10 * time.Minute
+	// Backing off never leaves longer than this between faces.
+	defaultMaxBackoff = time.Hour
+	// Each face enhanced after a throttle shortens the time between faces by this factor, until it is back to the rate set on the command line.
+	rateRampFactor = 0.75
+)
+
+// AdaptiveRateLimiter spaces faces out to a rate, and slows down when FaceApp starts refusing work.
+// Each throttle doubles the time between faces, up to MaxInterval. Each face enhanced afterwards ramps it back down, but never below Interval -- the rate set on the command line is the fastest the limiter goes.
+type AdaptiveRateLimiter struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+
+	mu        sync.Mutex
+	current   time.Duration
+	last      time.Time
+	throttles int
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+// A limiter of rate faces every per.
+func NewAdaptiveRateLimiter(rate int, per time.Duration, maxInterval time.Duration) (*AdaptiveRateLimiter, error) {
+	if rate <= 0 || per <= 0 {
+		return nil, errors.New("Rate limit must be at least one face in a positive duration")
+	}
+	interval := per / time.Duration(rate)
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return &AdaptiveRateLimiter{
+		Interval:    interval,
+		MaxInterval: maxInterval,
+		current:     interval,
+		now:         time.Now,
+		sleep:       sleepContext,
+	}, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Block until the next face is allowed, or ctx is done. The first face is allowed straight away.
+func (l *AdaptiveRateLimiter) Take(ctx context.Context) (time.Time, error) {
+	l.mu.Lock()
+	wait := time.Duration(0)
+	if !l.last.IsZero() {
+		wait = l.last.Add(l.current).Sub(l.now())
+	}
+	l.mu.Unlock()
+	if wait > 0 {
+		if err := l.sleep(ctx, wait); err != nil {
+			return time.Time{}, err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last = l.now()
+	return l.last, nil
+}
+
+// The earliest time the next face is allowed.
+func (l *AdaptiveRateLimiter) NextSlot() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last.IsZero() {
+		return l.now()
+	}
+	return l.last.Add(l.current)
+}
+
+// Back off after FaceApp refused a face. The wait for the next face is counted from now, rather than from when the refused face was taken.
+// Returns the time now left between faces.
+func (l *AdaptiveRateLimiter) Throttled() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttles++
+	l.current *= 2
+	if l.current > l.MaxInterval {
+		l.current = l.MaxInterval
+	}
+	l.last = l.now()
+	return l.current
+}
+
+// Ramp back up after a face was enhanced. Returns the time now left between faces.
+func (l *AdaptiveRateLimiter) Succeeded() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current > l.Interval {
+		l.current = time.Duration(float64(l.current) * rateRampFactor)
+		if l.current < l.Interval {
+			l.current = l.Interval
+		}
+	}
+	return l.current
+}
+
+// The number of times FaceApp has throttled the run.
+func (l *AdaptiveRateLimiter) Throttles() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.throttles
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRateLimiter(t *testing.T) {
+	limiter, err := NewAdaptiveRateLimiter(10, 10*time.Minute, 8*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2021, 12, 6, 10, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	limiter.now = func() time.Time { return clock }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+		return nil
+	}
+
+	if _, err := limiter.Take(context.Background()); err != nil || len(slept) != 0 {
+		t.Fatalf("Expected the first face straight away, slept %v - %v", slept, err)
+	}
+	clock = clock.Add(20 * time.Second)
+	if _, err := limiter.Take(context.Background()); err != nil || len(slept) != 1 || slept[0] != 40*time.Second {
+		t.Fatalf("Expected to wait out the rest of a minute, slept %v - %v", slept, err)
+	}
+
+	for _, expected := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 8 * time.Minute} {
+		if interval := limiter.Throttled(); interval != expected {
+			t.Errorf("Expected to back off to %v, got %v", expected, interval)
+		}
+	}
+	if limiter.Throttles() != 4 || !limiter.NextSlot().Equal(clock.Add(8*time.Minute)) {
+		t.Errorf("Expected the next slot to be counted from the throttle, got %v after %d throttles", limiter.NextSlot(), limiter.Throttles())
+	}
+
+	for _, expected := range []time.Duration{6 * time.Minute, 270 * time.Second} {
+		if interval := limiter.Succeeded(); interval != expected {
+			t.Errorf("Expected to ramp up to %v, got %v", expected, interval)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		limiter.Succeeded()
+	}
+	if interval := limiter.Succeeded(); interval != time.Minute {
+		t.Errorf("Expected to ramp no faster than the rate set, got %v", interval)
+	}
+}
+
+func TestAdaptiveRateLimiterInterrupted(t *testing.T) {
+	limiter, err := NewAdaptiveRateLimiter(1, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.MaxInterval != time.Hour {
+		t.Errorf("Expected the max interval to be at least the interval, got %v", limiter.MaxInterval)
+	}
+	if _, err := limiter.Take(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Take(ctx); err == nil {
+		t.Error("Expected an interrupted wait to be an error")
+	}
+	if _, err := NewAdaptiveRateLimiter(0, time.Minute, 0); err == nil {
+		t.Error("Expected a rate of 0 to be an error")
+	}
+}
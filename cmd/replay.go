@@ -0,0 +1,166 @@
+// A script to replay a recorded enhancement session against the template matching and face detection code.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"path"
+
+	"github.com/go-vgo/robotgo"
+	cli "github.com/spf13/cobra"
+)
+
+var (
+	replayCmd = &cli.Command{
+		Use:   "replay <dir>",
+		Short: "Replay a recorded enhancement session",
+		Long:  "Feed the screenshots of a session recorded with --record back through the BlueStacks template matching and face detection, and show where the decisions differ from the recording.",
+		Args:  cli.ExactArgs(1),
+		Run:   Replay,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.PersistentFlags().String("face-detector", "cascade", "Face detector to use -- cascade or dnn.")
+	replayCmd.PersistentFlags().StringP("cascade-file", "c", "", "Path to local cascaseFile used for OpenCV FaceDetect Classifier. Face detection decisions are skipped without it, unless the dnn face detector is used.")
+	replayCmd.PersistentFlags().String("dnn-model", "", "Path to the OpenCV DNN face detection model used by the dnn face detector.")
+	replayCmd.PersistentFlags().String("dnn-config", "", "Path to the OpenCV DNN face detection model config.")
+	replayCmd.PersistentFlags().Float32("dnn-min-confidence", 0.5, "Minimum confidence of a face found by the dnn face detector.")
+	replayCmd.PersistentFlags().Int("tolerance", 2, "Number of pixels a replayed coordinate may drift from the recording before it is reported.")
+}
+
+func Replay(cmd *cli.Command, args []string) {
+	sessionDir := args[0]
+	faceDetector, _ := cmd.Flags().GetString("face-detector")
+	cascadeFile, _ := cmd.Flags().GetString("cascade-file")
+	tolerance, _ := cmd.Flags().GetInt("tolerance")
+
+	meta, events, err := loadSession(sessionDir)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot load session %v - %v\n", sessionDir, err.Error())
+	}
+	log.Printf("Replaying %s session from %v with %d events on a %dx%d capture...\n", meta.Command, meta.StartedAt, len(events), meta.Capture.Width, meta.Capture.Height)
+
+	// The recorded coordinate space is used so that replayed coordinates are in the same space as the recorded ones.
+	bluestacks := &BlueStacks{}
+	bluestacks.SetCoordSpace(NewCoordSpace(meta.Capture))
+	detectFaces := faceDetector != "cascade" || cascadeFile != ""
+	if detectFaces {
+		bluestacks.FaceDetector, err = newFaceDetector(cmd)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err.Error())
+		}
+		defer bluestacks.FaceDetector.Close()
+	}
+
+	var screenImg image.Image
+	screenName := ""
+	replayed := 0
+	skipped := 0
+	var differences []string
+	for i, event := range events {
+		if event.Kind == ActionSpace && event.Space != nil {
+			bluestacks.SetCoordSpace(*event.Space)
+			continue
+		}
+		if event.Kind != ActionDecision || event.Decision == nil {
+			continue
+		}
+		if event.Decision.Kind == DecisionFaces && !detectFaces {
+			skipped++
+			continue
+		}
+		if event.Screen != screenName {
+			screenImg, _, err = robotgo.DecodeImg(path.Join(sessionDir, event.Screen))
+			if err != nil {
+				log.Printf("WARN: [Event %d] Cannot load screen %v - %v\n", i, event.Screen, err.Error())
+				screenName = ""
+				skipped++
+				continue
+			}
+			screenName = event.Screen
+		}
+
+		recorded := *event.Decision
+		var difference string
+		switch recorded.Kind {
+		case DecisionTemplate:
+			coords, confidence, err := replayTemplateDecision(bluestacks, recorded, screenImg)
+			difference = compareTemplateDecision(recorded, coords, confidence, err, tolerance)
+		case DecisionFaces:
+			faces := bluestacks.DetectFaces(screenImg, recorded.MinWidth)
+			difference = compareFacesDecision(recorded, faces, tolerance)
+		default:
+			skipped++
+			continue
+		}
+		replayed++
+		if difference != "" {
+			difference = fmt.Sprintf("[Event %d %v %v] %s", i, event.Time.Format("15:04:05"), event.Screen, difference)
+			log.Println(difference)
+			differences = append(differences, difference)
+		}
+	}
+
+	log.Printf("%d decisions replayed, %d differ from the recording, %d skipped\n", replayed, len(differences), skipped)
+}
+
+// Repeat a recorded template lookup. Lookups of manifest elements are searched within the same region and with the same confidence as when they were recorded.
+// Sessions recorded before elements were recorded are searched on the whole screen at the default confidence.
+func replayTemplateDecision(bluestacks *BlueStacks, recorded SessionDecision, screenImg image.Image) (Coords, float32, error) {
+	if recorded.Element == "" {
+		return bluestacks.GetImagePathCoordsInImage(recorded.Template, screenImg)
+	}
+	coords, confidence, err := bluestacks.locateImage(recorded.Template, screenImg, recorded.Region, recorded.MinConfidence)
+	if err != nil {
+		return coords, confidence, fmt.Errorf("%v: %s", err, recorded.Template)
+	}
+	return coords, confidence, nil
+}
+
+func withinTolerance(a, b, tolerance int) bool {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// Returns a description of how the replayed template lookup differs from the recorded one, or an empty string if it does not.
+func compareTemplateDecision(recorded SessionDecision, coords Coords, confidence float32, err error, tolerance int) string {
+	recordedFound := recorded.Error == ""
+	replayedFound := err == nil
+	if recordedFound != replayedFound {
+		if recordedFound {
+			return fmt.Sprintf("%v was found at %v (%.3f) but is now missing - %v", recorded.Template, recorded.Coords, recorded.Confidence, err.Error())
+		}
+		return fmt.Sprintf("%v was missing but is now found at %v (%.3f)", recorded.Template, coords, confidence)
+	}
+	if !replayedFound {
+		return ""
+	}
+	if !withinTolerance(recorded.Coords.X, coords.X, tolerance) || !withinTolerance(recorded.Coords.Y, coords.Y, tolerance) {
+		return fmt.Sprintf("%v moved from %v (%.3f) to %v (%.3f)", recorded.Template, recorded.Coords, recorded.Confidence, coords, confidence)
+	}
+	return ""
+}
+
+func compareFacesDecision(recorded SessionDecision, faces []FaceDetection, tolerance int) string {
+	recordedRects := faceRects(recorded.Faces)
+	rects := faceRects(faces)
+	if len(recordedRects) != len(rects) {
+		return fmt.Sprintf("%d faces were detected but now %d are - recorded %v, replayed %v", len(recordedRects), len(rects), recordedRects, rects)
+	}
+	for i, r := range recordedRects {
+		f := rects[i]
+		if !withinTolerance(r.Min.X, f.Min.X, tolerance) || !withinTolerance(r.Min.Y, f.Min.Y, tolerance) ||
+			!withinTolerance(r.Max.X, f.Max.X, tolerance) || !withinTolerance(r.Max.Y, f.Max.Y, tolerance) {
+			return fmt.Sprintf("face %d moved from %v to %v", i, r, f)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+// A script to build a self-contained HTML report of an enhancement run, for reviewers to sign off a batch.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/disintegration/imaging"
+	cli "github.com/spf13/cobra"
+)
+
+const (
+	ReportEnhanced = "enhanced"
+	ReportSkipped  = "skipped"
+	ReportFailed   = "failed"
+)
+
+var (
+	reportCmd = &cli.Command{
+		Use:   "report <runDir>",
+		Short: "Build an HTML report of an enhancement run",
+		Long:  "Build a self-contained HTML page of an enhancement run, showing each image's source and enhanced face side by side with the enhancements applied, the reasons images were skipped or quarantined, and the totals of each enhancement type.",
+		Args:  cli.ExactArgs(1),
+		Run:   Report,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.PersistentFlags().StringP("source", "s", "./output/step2", "Path to source image directory the run enhanced.")
+	reportCmd.PersistentFlags().StringP("output", "o", "", "Path to write the report to. Defaults to report.html in the run directory.")
+	reportCmd.PersistentFlags().Int("thumbnail-size", 160, "Width in pixels of the images embedded in the report.")
+}
+
+// RunReport is everything shown in the report of a run.
+type RunReport struct {
+	RunDir    string
+	CreatedAt time.Time
+	Images    []ReportImage
+	Totals    []ReportTotal
+	Enhanced  int
+	Skipped   int
+	Failed    int
+}
+
+// ReportImage is one image of the run. Thumbnails are data URIs, so the report has no files alongside it.
+type ReportImage struct {
+	Id              string
+	Status          string
+	Reason          string
+	Enhancements    []map[string]string
+	SourceThumb     template.URL
+	EnhancedThumb   template.URL
+	SourceMissing   bool
+	EnhancedMissing bool
+}
+
+// ReportTotal is the number of images given an enhancement type. Unverified enhancements made no visible change, so are counted apart.
+type ReportTotal struct {
+	Enhancement string
+	Type        string
+	Images      int
+	Unverified  int
+}
+
+func Report(cmd *cli.Command, args []string) {
+	runDir := args[0]
+	sourceDir, _ := cmd.Flags().GetString("source")
+	reportPath, _ := cmd.Flags().GetString("output")
+	thumbnailSize, _ := cmd.Flags().GetInt("thumbnail-size")
+	if reportPath == "" {
+		reportPath = path.Join(runDir, "report.html")
+	}
+
+	report, err := NewRunReport(runDir, sourceDir, thumbnailSize)
+	if err != nil {
+		logger.Step("report").Fatalf("Cannot build report of %v - %v", runDir, err.Error())
+	}
+	file, err := os.Create(reportPath)
+	if err != nil {
+		logger.Step("report").Fatalf("%v", err.Error())
+	}
+	defer file.Close()
+	err = reportTemplate.Execute(file, report)
+	if err != nil {
+		logger.Step("report").Fatalf("Cannot write report - %v", err.Error())
+	}
+	logger.Step("report").Infof("Report of %d enhanced, %d skipped and %d failed images written to %v", report.Enhanced, report.Skipped, report.Failed, reportPath)
+}
+
+// Gather the images of a run from its journal, skipped.json and failed.json.
+// The journal is read rather than index.json, so that a run that was interrupted can be reported too.
+func NewRunReport(runDir, sourceDir string, thumbnailSize int) (*RunReport, error) {
+	indexedImages, err := LoadRunJournal(runDir)
+	if err != nil {
+		return nil, err
+	}
+	skipped, err := LoadRunSkipped(runDir)
+	if err != nil {
+		return nil, err
+	}
+	recovery, err := NewRecoveryPolicy(runDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RunReport{RunDir: runDir, CreatedAt: time.Now()}
+	totals := map[string]*ReportTotal{}
+	// A resumed run journals an image once per attempt at it, so only the last entry counts
+	seen := map[string]int{}
+	for _, indexedImage := range indexedImages {
+		reported := ReportImage{
+			Id:           indexedImage.Id,
+			Status:       ReportEnhanced,
+			Enhancements: indexedImage.Enhancements,
+		}
+		reported.EnhancedThumb, reported.EnhancedMissing = reportThumbnail(thumbnailSize, indexedImage.EnhancedImagePath, path.Join(runDir, indexedImage.Id+".jpeg"))
+		if len(indexedImage.Enhancements) == 0 {
+			reported.Reason = "No enhancements chosen"
+			reported.EnhancedMissing = false
+		}
+		if i, found := seen[indexedImage.Id]; found {
+			report.Images[i] = reported
+			continue
+		}
+		seen[indexedImage.Id] = len(report.Images)
+		report.Images = append(report.Images, reported)
+	}
+	for _, reported := range report.Images {
+		for _, applied := range reported.Enhancements {
+			key := quotaKey(applied["name"], applied["type"])
+			if totals[key] == nil {
+				totals[key] = &ReportTotal{Enhancement: applied["name"], Type: applied["type"]}
+			}
+			if applied["unverified"] == "true" {
+				totals[key].Unverified++
+			} else {
+				totals[key].Images++
+			}
+		}
+	}
+	for _, s := range skipped {
+		if _, found := seen[s.Id]; !found {
+			reported := ReportImage{Id: s.Id, Status: ReportSkipped, Reason: s.Reason}
+			if s.Detail != "" {
+				reported.Reason += " (" + s.Detail + ")"
+			}
+			report.Images = append(report.Images, reported)
+		}
+	}
+	for _, failed := range recovery.Quarantined {
+		if _, found := seen[failed.Id]; !found {
+			report.Images = append(report.Images, ReportImage{Id: failed.Id, Status: ReportFailed, Reason: fmt.Sprintf("%v (after %d attempts)", failed.Reason, failed.Attempts)})
+		}
+	}
+
+	for i := range report.Images {
+		reported := &report.Images[i]
+		reported.SourceThumb, reported.SourceMissing = reportThumbnail(thumbnailSize, path.Join(sourceDir, reported.Id+".jpeg"))
+		switch reported.Status {
+		case ReportEnhanced:
+			report.Enhanced++
+		case ReportSkipped:
+			report.Skipped++
+		case ReportFailed:
+			report.Failed++
+		}
+	}
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool {
+		if report.Totals[i].Enhancement != report.Totals[j].Enhancement {
+			return report.Totals[i].Enhancement < report.Totals[j].Enhancement
+		}
+		return report.Totals[i].Type < report.Totals[j].Type
+	})
+	return report, nil
+}
+
+// A thumbnail of the first of the paths that can be decoded, as a JPEG data URI. Returns true when none of them can.
+func reportThumbnail(width int, imagePaths ...string) (template.URL, bool) {
+	for _, imagePath := range imagePaths {
+		if imagePath == "" {
+			continue
+		}
+		img, err := imaging.Open(imagePath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			logger.Step("report").Warnf("Cannot open %v - %v", imagePath, err.Error())
+			continue
+		}
+		thumbBytes, err := ImageToBytes(reportResize(img, width))
+		if err != nil {
+			logger.Step("report").Warnf("Cannot encode %v - %v", imagePath, err.Error())
+			continue
+		}
+		return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumbBytes)), false
+	}
+	return "", true
+}
+
+func reportResize(img image.Image, width int) image.Image {
+	if width <= 0 || img.Bounds().Dx() <= width {
+		return img
+	}
+	return imaging.Resize(img, width, 0, imaging.Box)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHtml))
+
+const reportHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Enhancement report - {{.RunDir}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.4em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+img { display: block; max-width: 100%; }
+.enhanced { background: #f4fbf4; }
+.skipped { background: #fafafa; color: #666; }
+.failed { background: #fdf1f1; }
+.missing { color: #a33; font-style: italic; }
+.unverified { color: #a60; }
+</style>
+</head>
+<body>
+<h1>Enhancement report</h1>
+<p>Run {{.RunDir}} - reported {{.CreatedAt.Format "2006-01-02 15:04:05"}}</p>
+<p>{{.Enhanced}} enhanced, {{.Skipped}} skipped, {{.Failed}} failed</p>
+
+<h2>Totals</h2>
+<table>
+<tr><th>Enhancement</th><th>Type</th><th>Images</th><th>Unverified</th></tr>
+{{range .Totals}}<tr><td>{{.Enhancement}}</td><td>{{.Type}}</td><td>{{.Images}}</td><td>{{.Unverified}}</td></tr>
+{{else}}<tr><td colspan="4">No enhancements applied</td></tr>
+{{end}}</table>
+
+<h2>Images</h2>
+<table>
+<tr><th>Id</th><th>Source</th><th>Enhanced</th><th>Enhancements</th><th>Status</th></tr>
+{{range .Images}}<tr class="{{.Status}}">
+<td>{{.Id}}</td>
+<td>{{if .SourceMissing}}<span class="missing">Source image not found</span>{{else}}<img src="{{.SourceThumb}}" alt="{{.Id}} source">{{end}}</td>
+<td>{{if .EnhancedThumb}}<img src="{{.EnhancedThumb}}" alt="{{.Id}} enhanced">{{else if .EnhancedMissing}}<span class="missing">Enhanced image not found</span>{{end}}</td>
+<td>{{range .Enhancements}}<div{{if eq (index . "unverified") "true"}} class="unverified"{{end}}>{{index . "name"}} : {{index . "type"}}{{if eq (index . "unverified") "true"}} (unverified){{end}}</div>{{end}}</td>
+<td>{{.Status}}{{if .Reason}} - {{.Reason}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`
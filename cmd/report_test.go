@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestRunReport(t *testing.T) {
+	runDir := t.TempDir()
+	sourceDir := t.TempDir()
+	journal, err := OpenRunJournal(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, indexedImage := range []IndexedImage{
+		{Id: "1", Enhancements: []map[string]string{{"name": "Beards", "type": "Full"}}},
+		{Id: "2", Enhancements: []map[string]string{{"name": "Beards", "type": "Full", "unverified": "true"}}},
+		{Id: "1", Enhancements: []map[string]string{{"name": "Beards", "type": "Goatee"}}, EnhancedImagePath: path.Join(runDir, "1.jpeg")},
+	} {
+		if err := journal.Append(indexedImage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal.Close()
+	if err := saveRunSkipped(runDir, []SkippedImage{{Id: "3", Reason: PolicySkipUnderage}}); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(runDir, failedImagesFile), []byte(`[{"id": "4", "reason": "Failed to Save", "attempts": 3}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imaging.New(320, 320, color.White)
+	for _, imagePath := range []string{path.Join(sourceDir, "1.jpeg"), path.Join(runDir, "1.jpeg")} {
+		if err := imaging.Save(img, imagePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := NewRunReport(runDir, sourceDir, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Enhanced != 2 || report.Skipped != 1 || report.Failed != 1 || len(report.Images) != 4 {
+		t.Fatalf("Unexpected report totals %+v", report)
+	}
+	first := report.Images[0]
+	if first.Id != "1" || first.Enhancements[0]["type"] != "Goatee" || first.SourceMissing || first.EnhancedMissing {
+		t.Errorf("Expected the last attempt at image 1 with both thumbnails, got %+v", first)
+	}
+	if !report.Images[1].SourceMissing || !report.Images[1].EnhancedMissing {
+		t.Errorf("Expected image 2 to be missing its images, got %+v", report.Images[1])
+	}
+	if len(report.Totals) != 2 || report.Totals[0].Type != "Full" || report.Totals[0].Unverified != 1 || report.Totals[0].Images != 0 || report.Totals[1].Images != 1 {
+		t.Errorf("Unexpected totals %+v", report.Totals)
+	}
+
+	var html strings.Builder
+	if err := reportTemplate.Execute(&html, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"data:image/jpeg;base64,", PolicySkipUnderage, "Failed to Save (after 3 attempts)", "(unverified)"} {
+		if !strings.Contains(html.String(), expected) {
+			t.Errorf("Expected the report to contain %q", expected)
+		}
+	}
+}
+
+func TestReportResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	if resized := reportResize(img, 160); resized.Bounds().Dx() != 160 || resized.Bounds().Dy() != 120 {
+		t.Errorf("Expected a 160x120 thumbnail, got %v", resized.Bounds())
+	}
+	if resized := reportResize(img, 1000); resized.Bounds() != img.Bounds() {
+		t.Errorf("Expected a small image to be kept, got %v", resized.Bounds())
+	}
+}
@@ -4,8 +4,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -31,18 +35,77 @@ func init() {
 	scanGeneratedCmd.PersistentFlags().StringP("source", "s", "./output/step1", "Path to source human images directory.")
 	scanGeneratedCmd.PersistentFlags().Int("max-queue", 20, "Maximum number of parallel images to process")
 	scanGeneratedCmd.PersistentFlags().Bool("simple", false, "Run the check simply. Only compare images immediately before and after the current source image.")
+	scanGeneratedCmd.PersistentFlags().String("resume", "", "Path to the checkpoint of an interrupted scan to resume. Source images it has scanned are not compared again.")
+}
+
+// ScanCheckpoint holds the results of an interrupted scan, so that it can be resumed without comparing the scanned images again.
+type ScanCheckpoint struct {
+	Source   string      `json:"source"`
+	Simple   bool        `json:"simple"`
+	Scanned  []string    `json:"scanned"` // Ids of the source images compared with every image they are compared to
+	Similars [][2]string `json:"similars"`
+}
+
+func LoadScanCheckpoint(checkpointPath string) (ScanCheckpoint, error) {
+	var checkpoint ScanCheckpoint
+	file, err := ioutil.ReadFile(checkpointPath)
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(file, &checkpoint)
+	if err != nil {
+		return checkpoint, fmt.Errorf("%v: %s", err, checkpointPath)
+	}
+	return checkpoint, nil
+}
+
+func (c ScanCheckpoint) Save(checkpointPath string) error {
+	checkpointJson, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(checkpointPath, checkpointJson, 0644)
 }
 
 func ScanGenerated(cmd *cli.Command, args []string) {
 	sourceDir, _ := cmd.Flags().GetString("source")
 	maxQueue, _ := cmd.Flags().GetInt("max-queue")
 	isSimple, _ := cmd.Flags().GetBool("simple")
+	resumePath, _ := cmd.Flags().GetString("resume")
+
+	checkpoint := ScanCheckpoint{Source: sourceDir, Simple: isSimple}
+	if resumePath != "" {
+		var err error
+		checkpoint, err = LoadScanCheckpoint(resumePath)
+		if err != nil {
+			log.Fatalf("ERROR: Cannot load scan checkpoint - %v", err.Error())
+		}
+		if checkpoint.Source != sourceDir || checkpoint.Simple != isSimple {
+			log.Fatalf("ERROR: Checkpoint %v is of a scan of %v (simple %v)", resumePath, checkpoint.Source, checkpoint.Simple)
+		}
+		log.Printf("Resuming scan with %d source images already scanned\n", len(checkpoint.Scanned))
+	}
+	scanned := map[string]bool{}
+	for _, srcId := range checkpoint.Scanned {
+		scanned[srcId] = true
+	}
+	// Record a source image once it has been compared with every image, along with the images similar to it
+	var mu sync.Mutex
+	recordScanned := func(srcId string, srcSimilars [][2]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		checkpoint.Scanned = append(checkpoint.Scanned, srcId)
+		checkpoint.Similars = append(checkpoint.Similars, srcSimilars...)
+	}
+
+	// An interrupted scan abandons the source images being compared, so that they are scanned again when it is resumed
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
 
 	queue := make(chan string, maxQueue)
 	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
 	s.Start()
 	sStatus := [][2]string{}
-	similars := [][2]string{}
 
 	// Populate the array.
 	for i := 0; i < maxQueue; i++ {
@@ -80,8 +143,15 @@ func ScanGenerated(cmd *cli.Command, args []string) {
 		// }
 
 		for i, sourceImagePath := range orderedPaths {
+			if interrupted(ctx) {
+				break
+			}
 			srcId := getFileName(sourceImagePath)
+			if scanned[srcId] {
+				continue
+			}
 			srcImg, _, _ := imgo.DecodeFile(sourceImagePath)
+			srcSimilars := [][2]string{}
 			var compareImagePaths []string
 			if i != 0 {
 				compareImagePaths = append(compareImagePaths, orderedPaths[i-1])
@@ -101,9 +171,10 @@ func ScanGenerated(cmd *cli.Command, args []string) {
 				cmpImg, _, _ := imgo.DecodeFile(compareImagePath)
 				isSimilar := imagesSimilar(srcImg, cmpImg)
 				if isSimilar {
-					similars = append(similars, [2]string{srcId, cmpId})
+					srcSimilars = append(srcSimilars, [2]string{srcId, cmpId})
 				}
 			}
+			recordScanned(srcId, srcSimilars)
 		}
 	} else {
 		// TODO: This process of scanning takes entirely too long because we're comparing Src to Cmp both ways... solve this if it becomes an issue?
@@ -112,12 +183,21 @@ func ScanGenerated(cmd *cli.Command, args []string) {
 			wg.Add(1)
 			go func(index int) {
 				for sourceImagePath := range queue {
+					if interrupted(ctx) {
+						continue // Drain the queue
+					}
 					srcFilename := filepath.Base(sourceImagePath)
 					srcExtension := filepath.Ext(srcFilename)
 					srcId := srcFilename[0 : len(srcFilename)-len(srcExtension)]
 					srcImg, _, _ := imgo.DecodeFile(sourceImagePath)
 					sStatus[index][0] = srcId
+					srcSimilars := [][2]string{}
+					abandoned := false
 					for _, compareImagePath := range filePaths {
+						if interrupted(ctx) {
+							abandoned = true
+							break
+						}
 						if sourceImagePath == compareImagePath {
 							continue
 						}
@@ -136,15 +216,24 @@ func ScanGenerated(cmd *cli.Command, args []string) {
 						cmpImg, _, _ := imgo.DecodeFile(compareImagePath)
 						isSimilar := imagesSimilar(srcImg, cmpImg)
 						if isSimilar {
-							similars = append(similars, [2]string{srcId, cmpId})
+							srcSimilars = append(srcSimilars, [2]string{srcId, cmpId})
 						}
 					}
+					if !abandoned {
+						recordScanned(srcId, srcSimilars)
+					}
 				}
 				wg.Done()
 			}(i)
 		}
 
 		for _, sourceImagePath := range filePaths {
+			if interrupted(ctx) {
+				break
+			}
+			if scanned[getFileName(sourceImagePath)] {
+				continue
+			}
 			queue <- sourceImagePath
 		}
 
@@ -154,8 +243,24 @@ func ScanGenerated(cmd *cli.Command, args []string) {
 
 	s.Stop()
 
-	log.Println("All done!\nResult:")
-	for _, similar := range similars {
+	if interrupted(ctx) {
+		log.Println("Interrupted!\nResult so far:")
+	} else {
+		log.Println("All done!\nResult:")
+	}
+	for _, similar := range checkpoint.Similars {
 		log.Println(fmt.Sprintf("Source %s similar to %s", similar[0], similar[1]))
 	}
+
+	if interrupted(ctx) {
+		checkpointPath := fmt.Sprintf("./tmp/scan-checkpoint-%d.json", currentTs)
+		err = os.MkdirAll(path.Dir(checkpointPath), 0755)
+		if err == nil {
+			err = checkpoint.Save(checkpointPath)
+		}
+		if err != nil {
+			log.Printf("WARN: Cannot write scan checkpoint - %v\n", err.Error())
+		}
+		notifyInterrupted(fmt.Sprintf("%d of %d source images scanned, %d similar pairs found. Resume with scan --resume %v", len(checkpoint.Scanned), len(filePaths), len(checkpoint.Similars), checkpointPath))
+	}
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/go-vgo/robotgo"
+)
+
+// ScreenDriver is the backend BlueStacks uses to see and drive the desktop.
+// Everything that captures the screen, moves the pointer or types goes through here, so the enhance flows can run against a fake screen.
+type ScreenDriver interface {
+	FocusProcess(processName string) (int32, error)
+	// The region of the desktop each capture covers, in pointer coordinates.
+	CaptureBounds() Bounds
+	CaptureImg() image.Image
+	Move(x, y int)
+	Click()
+	DragSmooth(x, y int)
+	KeyTap(key string, modifiers ...string)
+	MilliSleep(ms int)
+}
+
+// DriverAction is a single pointer, keyboard or capture action received by a driver.
+type DriverAction struct {
+	Kind      string   `json:"kind"`
+	X         int      `json:"x,omitempty"`
+	Y         int      `json:"y,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Modifiers []string `json:"modifiers,omitempty"`
+	Duration  int      `json:"duration,omitempty"`
+}
+
+const (
+	ActionCapture = "capture"
+	ActionMove    = "move"
+	ActionClick   = "click"
+	ActionDrag    = "drag"
+	ActionKey     = "key"
+	ActionSleep   = "sleep"
+)
+
+// RobotgoDriver drives the live desktop with robotgo. It is the default backend.
+// Captures cover the whole screen unless Region is set.
+type RobotgoDriver struct {
+	Region *Bounds
+}
+
+func NewRobotgoDriver() *RobotgoDriver {
+	robotgo.KeySleep = 100
+	robotgo.MouseSleep = 100
+	return &RobotgoDriver{}
+}
+
+func (d *RobotgoDriver) FocusProcess(processName string) (int32, error) {
+	fpid, err := robotgo.FindIds(processName)
+	if err != nil {
+		return 0, err
+	}
+	if len(fpid) == 0 {
+		return 0, fmt.Errorf("%s is not running", processName)
+	}
+	// Ensure that the main process pid is used
+	var activePid int32
+	for _, pid := range fpid {
+		name, err := robotgo.FindName(pid)
+		if err != nil {
+			return 0, err
+		}
+		if name == processName {
+			activePid = pid
+			break
+		}
+	}
+	err = robotgo.ActivePID(activePid)
+	if err != nil {
+		return 0, err
+	}
+	return activePid, nil
+}
+
+func (d *RobotgoDriver) CaptureBounds() Bounds {
+	if d.Region != nil {
+		return *d.Region
+	}
+	screenWidth, screenHeight := robotgo.GetScreenSize()
+	return Bounds{
+		Width:  screenWidth,
+		Height: screenHeight,
+	}
+}
+
+func (d *RobotgoDriver) CaptureImg() image.Image {
+	if d.Region != nil {
+		return robotgo.CaptureImg(d.Region.Left, d.Region.Top, d.Region.Width, d.Region.Height)
+	}
+	return robotgo.CaptureImg()
+}
+
+func (d *RobotgoDriver) Move(x, y int) {
+	robotgo.Move(x, y)
+}
+
+func (d *RobotgoDriver) Click() {
+	robotgo.Click()
+}
+
+func (d *RobotgoDriver) DragSmooth(x, y int) {
+	robotgo.DragSmooth(x, y)
+}
+
+func (d *RobotgoDriver) KeyTap(key string, modifiers ...string) {
+	args := make([]interface{}, len(modifiers))
+	for i, m := range modifiers {
+		args[i] = m
+	}
+	robotgo.KeyTap(key, args...)
+}
+
+func (d *RobotgoDriver) MilliSleep(ms int) {
+	robotgo.MilliSleep(ms)
+}
+
+// MemoryDriver serves screenshots from disk and records every action it receives instead of touching the desktop.
+// Each capture returns the next screenshot in order, and the last screenshot is repeated once they run out.
+type MemoryDriver struct {
+	Width   int
+	Height  int
+	Screens []image.Image
+	Actions []DriverAction
+
+	mu         sync.Mutex
+	nextScreen int
+}
+
+func NewMemoryDriver(screenPaths []string) (*MemoryDriver, error) {
+	if len(screenPaths) == 0 {
+		return nil, errors.New("memory driver requires at least one screenshot")
+	}
+	d := &MemoryDriver{}
+	for _, screenPath := range screenPaths {
+		img, _, err := robotgo.DecodeImg(screenPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, screenPath)
+		}
+		d.Screens = append(d.Screens, img)
+	}
+	d.Width = d.Screens[0].Bounds().Dx()
+	d.Height = d.Screens[0].Bounds().Dy()
+	return d, nil
+}
+
+func (d *MemoryDriver) record(action DriverAction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Actions = append(d.Actions, action)
+}
+
+func (d *MemoryDriver) FocusProcess(processName string) (int32, error) {
+	return 0, nil
+}
+
+func (d *MemoryDriver) CaptureBounds() Bounds {
+	return Bounds{
+		Width:  d.Width,
+		Height: d.Height,
+	}
+}
+
+func (d *MemoryDriver) CaptureImg() image.Image {
+	d.record(DriverAction{Kind: ActionCapture})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	img := d.Screens[d.nextScreen]
+	if d.nextScreen < len(d.Screens)-1 {
+		d.nextScreen++
+	}
+	return img
+}
+
+func (d *MemoryDriver) Move(x, y int) {
+	d.record(DriverAction{Kind: ActionMove, X: x, Y: y})
+}
+
+func (d *MemoryDriver) Click() {
+	d.record(DriverAction{Kind: ActionClick})
+}
+
+func (d *MemoryDriver) DragSmooth(x, y int) {
+	d.record(DriverAction{Kind: ActionDrag, X: x, Y: y})
+}
+
+func (d *MemoryDriver) KeyTap(key string, modifiers ...string) {
+	d.record(DriverAction{Kind: ActionKey, Key: key, Modifiers: modifiers})
+}
+
+// Sleeps are recorded but not waited on, so headless runs are not held up by animation delays.
+func (d *MemoryDriver) MilliSleep(ms int) {
+	d.record(DriverAction{Kind: ActionSleep, Duration: ms})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestMemoryDriver(t *testing.T, screens ...string) *MemoryDriver {
+	cwd, _ := os.Getwd()
+	cwd = path.Join(cwd, "../")
+	var screenPaths []string
+	for _, screen := range screens {
+		screenPaths = append(screenPaths, path.Join(cwd, "./test/screens", screen))
+	}
+	driver, err := NewMemoryDriver(screenPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return driver
+}
+
+func TestMemoryDriverRecordsActions(t *testing.T) {
+	driver := newTestMemoryDriver(t, "editor-screen-Eyebrows--1638706561.jpg")
+	bluestacks := NewBlueStacks(driver)
+	if bluestacks.ScreenWidth != driver.Width || bluestacks.ScreenHeight != driver.Height {
+		t.Fatalf("Screen size %dx%d does not match the served screenshot %dx%d", bluestacks.ScreenWidth, bluestacks.ScreenHeight, driver.Width, driver.Height)
+	}
+
+	bluestacks.MoveClick(10, 20)
+	bluestacks.TypeStr("ab")
+	_ = bluestacks.OsBackClick()
+
+	expected := []DriverAction{
+		{Kind: ActionMove, X: 10, Y: 20},
+		{Kind: ActionSleep, Duration: 300},
+		{Kind: ActionClick},
+		{Kind: ActionKey, Key: "a"},
+		{Kind: ActionKey, Key: "b"},
+		{Kind: ActionKey, Key: "esc"},
+	}
+	if len(driver.Actions) != len(expected) {
+		t.Fatalf("Expected %d actions, got %d - %v", len(expected), len(driver.Actions), driver.Actions)
+	}
+	for i, action := range driver.Actions {
+		if action.Kind != expected[i].Kind || action.X != expected[i].X || action.Y != expected[i].Y || action.Key != expected[i].Key || action.Duration != expected[i].Duration {
+			t.Errorf("Action %d - expected %v, got %v", i, expected[i], action)
+		}
+	}
+}
+
+func TestMemoryDriverServesScreensInOrder(t *testing.T) {
+	driver := newTestMemoryDriver(t, "editor-screen-Eyebrows--1638706561.jpg", "editor-screen-Petite Goatee--1638866689.jpg")
+	bluestacks := NewBlueStacks(driver)
+
+	// The two editor screens differ, so a scroll between captures registers as a change.
+	if !bluestacks.CanSrollDown(100) {
+		t.Error("Expected a scroll to be detected between two different screens")
+	}
+	// Once the screenshots run out, the last one is repeated and nothing changes.
+	if bluestacks.CanSrollDown(100) {
+		t.Error("Expected no scroll to be detected once the last screen repeats")
+	}
+}
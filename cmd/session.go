@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	cli "github.com/spf13/cobra"
+)
+
+const (
+	sessionLogFile  = "session.jsonl"
+	sessionMetaFile = "session.json"
+	sessionScreens  = "screens"
+	// Decisions are made against recent captures, so only the last few screenshots are kept in memory.
+	sessionRecentScreens = 16
+
+	ActionDecision = "decision"
+	ActionSpace    = "space"
+
+	DecisionTemplate = "template"
+	DecisionFaces    = "faces"
+)
+
+// SessionMeta describes the screen a session was recorded against.
+type SessionMeta struct {
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"startedAt"`
+	Capture   Bounds    `json:"capture"`
+}
+
+// SessionDecision is the outcome of a template lookup or face detection against a recorded screenshot.
+// Lookups of manifest elements also record the element, the region searched and the confidence required, so that a replay repeats the same search.
+type SessionDecision struct {
+	Kind          string          `json:"kind"`
+	Element       string          `json:"element,omitempty"`
+	Template      string          `json:"template,omitempty"`
+	Region        *AssetRegion    `json:"region,omitempty"`
+	MinConfidence float32         `json:"minConfidence,omitempty"`
+	Coords        Coords          `json:"coords"`
+	Confidence    float32         `json:"confidence,omitempty"`
+	MinWidth      float64         `json:"minWidth,omitempty"`
+	Faces         []FaceDetection `json:"faces,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// SessionEvent is a single line of the session log.
+type SessionEvent struct {
+	Time time.Time `json:"time"`
+	DriverAction
+	Screen   string           `json:"screen,omitempty"`
+	Decision *SessionDecision `json:"decision,omitempty"`
+	Space    *CoordSpace      `json:"space,omitempty"`
+}
+
+// DecisionRecorder is implemented by drivers that also want to log what BlueStacks decided from each screenshot.
+type DecisionRecorder interface {
+	RecordDecision(screen image.Image, decision SessionDecision)
+	RecordCoordSpace(space CoordSpace)
+}
+
+var sessionScreenEncoder = png.Encoder{CompressionLevel: png.BestSpeed}
+
+// RecordingDriver wraps another driver, saving every screenshot and every action it passes through to a session directory.
+type RecordingDriver struct {
+	Driver ScreenDriver
+	Dir    string
+
+	mu            sync.Mutex
+	logFile       *os.File
+	screenCount   int
+	recentScreens []recordedScreen
+}
+
+type recordedScreen struct {
+	Img  image.Image
+	Name string
+}
+
+func NewRecordingDriver(driver ScreenDriver, dir string, command string) (*RecordingDriver, error) {
+	err := os.MkdirAll(path.Join(dir, sessionScreens), 0755)
+	if err != nil {
+		return nil, err
+	}
+	metaJson, err := json.Marshal(SessionMeta{
+		Command:   command,
+		StartedAt: time.Now(),
+		Capture:   driver.CaptureBounds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path.Join(dir, sessionMetaFile), metaJson, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(path.Join(dir, sessionLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordingDriver{
+		Driver:  driver,
+		Dir:     dir,
+		logFile: logFile,
+	}, nil
+}
+
+func (d *RecordingDriver) Close() error {
+	return d.logFile.Close()
+}
+
+func (d *RecordingDriver) record(event SessionEvent) {
+	event.Time = time.Now()
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("WARN: Cannot marshal session event - %v\n", err.Error())
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err = d.logFile.Write(append(eventJson, '\n'))
+	if err != nil {
+		log.Printf("WARN: Cannot write session event - %v\n", err.Error())
+	}
+}
+
+// Screenshots are saved as PNG so that a replay matches against exactly the pixels the run saw.
+func (d *RecordingDriver) saveScreen(img image.Image) string {
+	d.mu.Lock()
+	d.screenCount++
+	name := path.Join(sessionScreens, fmt.Sprintf("%06d.png", d.screenCount))
+	if img != nil && reflect.TypeOf(img).Comparable() {
+		d.recentScreens = append(d.recentScreens, recordedScreen{Img: img, Name: name})
+		if len(d.recentScreens) > sessionRecentScreens {
+			d.recentScreens = d.recentScreens[1:]
+		}
+	}
+	d.mu.Unlock()
+
+	file, err := os.Create(path.Join(d.Dir, name))
+	if err != nil {
+		log.Printf("WARN: Cannot save session screen %v - %v\n", name, err.Error())
+		return name
+	}
+	defer file.Close()
+	err = sessionScreenEncoder.Encode(file, img)
+	if err != nil {
+		log.Printf("WARN: Cannot encode session screen %v - %v\n", name, err.Error())
+	}
+	return name
+}
+
+func (d *RecordingDriver) screenName(img image.Image) string {
+	if img == nil || !reflect.TypeOf(img).Comparable() {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, screen := range d.recentScreens {
+		if screen.Img == img {
+			return screen.Name
+		}
+	}
+	return ""
+}
+
+func (d *RecordingDriver) RecordDecision(screen image.Image, decision SessionDecision) {
+	name := d.screenName(screen)
+	if name == "" {
+		// Only decisions made against a captured screenshot can be replayed.
+		return
+	}
+	d.record(SessionEvent{
+		DriverAction: DriverAction{Kind: ActionDecision},
+		Screen:       name,
+		Decision:     &decision,
+	})
+}
+
+// The coordinate space is logged so that a replay converts screenshot pixels to the same pointer coordinates as the run.
+func (d *RecordingDriver) RecordCoordSpace(space CoordSpace) {
+	d.record(SessionEvent{
+		DriverAction: DriverAction{Kind: ActionSpace},
+		Space:        &space,
+	})
+}
+
+func (d *RecordingDriver) FocusProcess(processName string) (int32, error) {
+	return d.Driver.FocusProcess(processName)
+}
+
+func (d *RecordingDriver) CaptureBounds() Bounds {
+	return d.Driver.CaptureBounds()
+}
+
+func (d *RecordingDriver) CaptureImg() image.Image {
+	img := d.Driver.CaptureImg()
+	name := d.saveScreen(img)
+	d.record(SessionEvent{
+		DriverAction: DriverAction{Kind: ActionCapture},
+		Screen:       name,
+	})
+	return img
+}
+
+func (d *RecordingDriver) Move(x, y int) {
+	d.record(SessionEvent{DriverAction: DriverAction{Kind: ActionMove, X: x, Y: y}})
+	d.Driver.Move(x, y)
+}
+
+func (d *RecordingDriver) Click() {
+	d.record(SessionEvent{DriverAction: DriverAction{Kind: ActionClick}})
+	d.Driver.Click()
+}
+
+func (d *RecordingDriver) DragSmooth(x, y int) {
+	d.record(SessionEvent{DriverAction: DriverAction{Kind: ActionDrag, X: x, Y: y}})
+	d.Driver.DragSmooth(x, y)
+}
+
+func (d *RecordingDriver) KeyTap(key string, modifiers ...string) {
+	d.record(SessionEvent{DriverAction: DriverAction{Kind: ActionKey, Key: key, Modifiers: modifiers}})
+	d.Driver.KeyTap(key, modifiers...)
+}
+
+func (d *RecordingDriver) MilliSleep(ms int) {
+	d.record(SessionEvent{DriverAction: DriverAction{Kind: ActionSleep, Duration: ms}})
+	d.Driver.MilliSleep(ms)
+}
+
+// Create the screen driver for a command -- wrapping the live desktop in a recorder when --record is set.
+// Captures are limited to the BlueStacks window when --window is set.
+func newScreenDriver(cmd *cli.Command) ScreenDriver {
+	robotgoDriver := NewRobotgoDriver()
+	windowBounds, _ := cmd.Flags().GetString("window")
+	if windowBounds != "" {
+		window, err := parseBounds(windowBounds)
+		if err != nil {
+			log.Fatalf("ERROR: Invalid --window - %v\n", err.Error())
+		}
+		robotgoDriver.Region = &window
+	}
+	var driver ScreenDriver = robotgoDriver
+	recordDir, _ := cmd.Flags().GetString("record")
+	if recordDir == "" {
+		return driver
+	}
+	recorder, err := NewRecordingDriver(driver, recordDir, cmd.Name())
+	if err != nil {
+		log.Fatalf("ERROR: Cannot start session recording - %v\n", err.Error())
+	}
+	log.Printf("Recording session to %v\n", recordDir)
+	return recorder
+}
+
+func loadSession(dir string) (SessionMeta, []SessionEvent, error) {
+	var meta SessionMeta
+	var events []SessionEvent
+	metaFile, err := ioutil.ReadFile(path.Join(dir, sessionMetaFile))
+	if err != nil {
+		return meta, events, err
+	}
+	err = json.Unmarshal(metaFile, &meta)
+	if err != nil {
+		return meta, events, err
+	}
+	logFile, err := os.Open(path.Join(dir, sessionLogFile))
+	if err != nil {
+		return meta, events, err
+	}
+	defer logFile.Close()
+	decoder := json.NewDecoder(logFile)
+	for decoder.More() {
+		var event SessionEvent
+		err = decoder.Decode(&event)
+		if err != nil {
+			// A run that was killed mid-write leaves a partial last line -- keep everything before it.
+			log.Printf("WARN: Session log truncated after %d events - %v\n", len(events), err.Error())
+			break
+		}
+		events = append(events, event)
+	}
+	return meta, events, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRecordingDriverSessionRoundTrip(t *testing.T) {
+	sessionDir := t.TempDir()
+	memoryDriver := newTestMemoryDriver(t, "editor-screen-Eyebrows--1638706561.jpg")
+	recorder, err := NewRecordingDriver(memoryDriver, sessionDir, "enhance-v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bluestacks := NewBlueStacks(recorder)
+	space := NewCoordSpace(Bounds{Left: 1920, Width: memoryDriver.Width, Height: memoryDriver.Height})
+	bluestacks.SetCoordSpace(space)
+
+	screenImg := bluestacks.Driver.CaptureImg()
+	bluestacks.recordDecision(screenImg, SessionDecision{Kind: DecisionTemplate, Element: "apply", Template: "./assets/faceapp/apply.png", Region: &AssetRegion{Top: 0.5, Width: 1, Height: 0.5}, MinConfidence: 0.8, Coords: Coords{X: 5, Y: 6}})
+	bluestacks.MoveClick(5, 6)
+	// Decisions against images that were never captured cannot be replayed, so they are not logged.
+	bluestacks.recordDecision(image.NewRGBA(image.Rect(0, 0, 10, 10)), SessionDecision{Kind: DecisionTemplate})
+	_ = recorder.Close()
+
+	meta, events, err := loadSession(sessionDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Command != "enhance-v2" || meta.Capture.Width != memoryDriver.Width || meta.Capture.Height != memoryDriver.Height {
+		t.Errorf("Unexpected session meta %v", meta)
+	}
+	kinds := []string{ActionSpace, ActionSpace, ActionCapture, ActionDecision, ActionMove, ActionSleep, ActionClick}
+	if len(events) != len(kinds) {
+		t.Fatalf("Expected %d events, got %d - %v", len(kinds), len(events), events)
+	}
+	for i, event := range events {
+		if event.Kind != kinds[i] {
+			t.Errorf("Event %d - expected %s, got %s", i, kinds[i], event.Kind)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("Event %d has no timestamp", i)
+		}
+	}
+	if events[1].Space == nil || *events[1].Space != space {
+		t.Errorf("Coordinate space not recorded - %v", events[1])
+	}
+	if events[3].Screen != events[2].Screen || events[3].Decision.Coords != (Coords{X: 5, Y: 6}) {
+		t.Errorf("Decision not linked to the captured screen - %v", events[3])
+	}
+	if decision := events[3].Decision; decision.Element != "apply" || decision.Region == nil || *decision.Region != (AssetRegion{Top: 0.5, Width: 1, Height: 0.5}) || decision.MinConfidence != 0.8 {
+		t.Errorf("Search of the element not recorded for replay - %+v", decision)
+	}
+	if _, err := os.Stat(path.Join(sessionDir, events[2].Screen)); err != nil {
+		t.Errorf("Captured screen was not saved - %v", err)
+	}
+}
+
+func TestCompareTemplateDecision(t *testing.T) {
+	recorded := SessionDecision{Kind: DecisionTemplate, Template: "apply.png", Coords: Coords{X: 100, Y: 200}, Confidence: 0.9}
+	if diff := compareTemplateDecision(recorded, Coords{X: 101, Y: 198}, 0.88, nil, 2); diff != "" {
+		t.Errorf("Expected no difference within tolerance, got %q", diff)
+	}
+	if diff := compareTemplateDecision(recorded, Coords{X: 110, Y: 200}, 0.88, nil, 2); diff == "" {
+		t.Error("Expected a moved template to be reported")
+	}
+	if diff := compareTemplateDecision(recorded, Coords{}, 0, errors.New("Cannot find image inside of source image"), 2); diff == "" {
+		t.Error("Expected a missing template to be reported")
+	}
+	recorded.Error = "Cannot find image inside of source image"
+	if diff := compareTemplateDecision(recorded, Coords{}, 0, errors.New("Cannot find image inside of source image"), 2); diff != "" {
+		t.Errorf("Expected no difference when both lookups fail, got %q", diff)
+	}
+}
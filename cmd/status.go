@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"image"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Width in pixels the last screenshot is scaled down to when served.
+	statusScreenshotWidth = 800
+	// How long in-flight status requests are given to finish when the run ends.
+	statusShutdownTimeout = 5 * time.Second
+)
+
+// RunStatus is the progress of an enhancement run, as served by the status endpoint.
+// The engine updates it as faces are sourced and finished, while the status server reads it from its own goroutines.
+// A nil RunStatus ignores every update, so the engine does not need to check whether --status-addr is set.
+type RunStatus struct {
+	Command   string
+	StartedAt time.Time
+
+	mu              sync.Mutex
+	total           int // Faces the source will provide, or 0 when it cannot tell ahead of time.
+	index           int
+	imageId         string
+	enhanced        int
+	skipped         int
+	failed          int
+	retried         int
+	alreadyEnhanced int
+	nextSlot        time.Time
+	screen          image.Image
+	screenAt        time.Time
+}
+
+// StatusSnapshot is the status of a run at a point in time.
+type StatusSnapshot struct {
+	Command         string    `json:"command"`
+	StartedAt       time.Time `json:"startedAt"`
+	Index           int       `json:"index"`
+	ImageId         string    `json:"imageId,omitempty"`
+	Total           int       `json:"total,omitempty"`
+	Enhanced        int       `json:"enhanced"`
+	Skipped         int       `json:"skipped"`
+	Failed          int       `json:"failed"`
+	Retried         int       `json:"retried"`
+	AlreadyEnhanced int       `json:"alreadyEnhanced"`
+	// Images enhanced per hour since the run started.
+	Throughput          float64    `json:"throughputPerHour"`
+	NextSlot            *time.Time `json:"nextSlot,omitempty"`
+	LastScreenshotAt    *time.Time `json:"lastScreenshotAt,omitempty"`
+	EstimatedCompletion *time.Time `json:"estimatedCompletion,omitempty"`
+}
+
+func NewRunStatus(command string) *RunStatus {
+	return &RunStatus{
+		Command:   command,
+		StartedAt: time.Now(),
+	}
+}
+
+func (s *RunStatus) SetTotal(total int) {
+	s.update(func() { s.total = total })
+}
+
+// Record the face the run is working on.
+func (s *RunStatus) Sourced(face SourcedFace) {
+	s.update(func() { s.index, s.imageId = face.Index, face.ImageId })
+}
+
+func (s *RunStatus) Enhanced() {
+	s.update(func() { s.enhanced++ })
+}
+
+func (s *RunStatus) Skipped() {
+	s.update(func() { s.skipped++ })
+}
+
+// Record a face that failed every attempt at it, and was quarantined.
+func (s *RunStatus) Failed() {
+	s.update(func() { s.failed++ })
+}
+
+// Record a face that failed and will be sourced again.
+func (s *RunStatus) Retried() {
+	s.update(func() { s.retried++ })
+}
+
+// Record a face that was enhanced by the run being resumed.
+func (s *RunStatus) AlreadyEnhanced() {
+	s.update(func() { s.alreadyEnhanced++ })
+}
+
+func (s *RunStatus) update(f func()) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+// Record the earliest time the rate limiter lets the next face be opened.
+func (s *RunStatus) SetNextSlot(nextSlot time.Time) {
+	s.update(func() { s.nextSlot = nextSlot })
+}
+
+func (s *RunStatus) SetScreen(screen image.Image) {
+	s.update(func() { s.screen, s.screenAt = screen, time.Now() })
+}
+
+func (s *RunStatus) Screen() (image.Image, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.screen, s.screenAt
+}
+
+// The status of the run at now.
+// Completion is estimated from the average time of each attempt at a face so far -- faces enhanced by the run being resumed take no time, so they are left out of the average.
+func (s *RunStatus) Snapshot(now time.Time) StatusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := StatusSnapshot{
+		Command:         s.Command,
+		StartedAt:       s.StartedAt,
+		Index:           s.index,
+		ImageId:         s.imageId,
+		Total:           s.total,
+		Enhanced:        s.enhanced,
+		Skipped:         s.skipped,
+		Failed:          s.failed,
+		Retried:         s.retried,
+		AlreadyEnhanced: s.alreadyEnhanced,
+	}
+	elapsed := now.Sub(s.StartedAt)
+	if elapsed > 0 {
+		snapshot.Throughput = float64(s.enhanced) / elapsed.Hours()
+	}
+	if !s.nextSlot.IsZero() {
+		nextSlot := s.nextSlot
+		snapshot.NextSlot = &nextSlot
+	}
+	if !s.screenAt.IsZero() {
+		screenAt := s.screenAt
+		snapshot.LastScreenshotAt = &screenAt
+	}
+	finished := s.enhanced + s.skipped + s.failed + s.alreadyEnhanced
+	attempts := finished - s.alreadyEnhanced + s.retried
+	remaining := s.total + s.retried - finished
+	if s.total > 0 && attempts > 0 && remaining >= 0 {
+		completion := now.Add(elapsed / time.Duration(attempts) * time.Duration(remaining))
+		snapshot.EstimatedCompletion = &completion
+	}
+	return snapshot
+}
+
+// StatusDriver wraps another driver, keeping the last screenshot it captured for the status endpoint.
+type StatusDriver struct {
+	ScreenDriver
+	Status *RunStatus
+}
+
+func (d *StatusDriver) CaptureImg() image.Image {
+	img := d.ScreenDriver.CaptureImg()
+	d.Status.SetScreen(img)
+	return img
+}
+
+// Decisions are passed on to a recording driver underneath, so that --record and --status-addr can be used together.
+func (d *StatusDriver) RecordDecision(screen image.Image, decision SessionDecision) {
+	if recorder, ok := d.ScreenDriver.(DecisionRecorder); ok {
+		recorder.RecordDecision(screen, decision)
+	}
+}
+
+func (d *StatusDriver) RecordCoordSpace(space CoordSpace) {
+	if recorder, ok := d.ScreenDriver.(DecisionRecorder); ok {
+		recorder.RecordCoordSpace(space)
+	}
+}
+
+// StatusServer serves the status of a run on the LAN -- as JSON at /status.json, the last screenshot at /screenshot.jpeg, and a page of both at /.
+type StatusServer struct {
+	Addr   string
+	Status *RunStatus
+
+	server *http.Server
+}
+
+// Listen on the address and serve the status in the background. The listener is opened up front, so that an address in use fails the command rather than the run.
+func StartStatusServer(addr string, status *RunStatus) (*StatusServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatusServer{Addr: listener.Addr().String(), Status: status}
+	s.server = &http.Server{Handler: s.Handler()}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Step("status").Warnf("Status server stopped - %v", err.Error())
+		}
+	}()
+	return s, nil
+}
+
+func (s *StatusServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *StatusServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Status.Snapshot(time.Now()))
+	})
+	mux.HandleFunc("/screenshot.jpeg", func(w http.ResponseWriter, r *http.Request) {
+		screen, _ := s.Status.Screen()
+		if screen == nil {
+			http.NotFound(w, r)
+			return
+		}
+		screenBytes, err := ImageToBytes(reportResize(screen, statusScreenshotWidth))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(screenBytes)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := statusTemplate.Execute(w, s.Status.Snapshot(time.Now()))
+		if err != nil {
+			logger.Step("status").Warnf("Cannot write status page - %v", err.Error())
+		}
+	})
+	return mux
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(statusHtml))
+
+const statusHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>{{.Command}} - {{.Enhanced}} enhanced</title>
+<style>
+body { font-family: sans-serif; margin: 1em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; }
+img { display: block; max-width: 100%; }
+</style>
+</head>
+<body>
+<h1>{{.Command}}</h1>
+<table>
+<tr><th>Started</th><td>{{.StartedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Current image</th><td>{{if .ImageId}}{{.ImageId}} (index {{.Index}}){{else}}-{{end}}</td></tr>
+<tr><th>Enhanced</th><td>{{.Enhanced}}{{if .Total}} of {{.Total}}{{end}}</td></tr>
+<tr><th>Skipped</th><td>{{.Skipped}}</td></tr>
+<tr><th>Failed</th><td>{{.Failed}} ({{.Retried}} retries)</td></tr>
+<tr><th>Already enhanced</th><td>{{.AlreadyEnhanced}}</td></tr>
+<tr><th>Throughput</th><td>{{printf "%.1f" .Throughput}} images per hour</td></tr>
+<tr><th>Next slot</th><td>{{with .NextSlot}}{{.Format "15:04:05"}}{{else}}-{{end}}</td></tr>
+<tr><th>Estimated completion</th><td>{{with .EstimatedCompletion}}{{.Format "2006-01-02 15:04"}}{{else}}-{{end}}</td></tr>
+</table>
+{{with .LastScreenshotAt}}<p>Last screenshot at {{.Format "15:04:05"}}</p>
+<img src="/screenshot.jpeg" alt="Last screenshot">{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStatusSnapshot(t *testing.T) {
+	status := NewRunStatus("npc enhance-v2")
+	status.StartedAt = time.Date(2021, 12, 6, 10, 0, 0, 0, time.UTC)
+	status.SetTotal(10)
+	status.AlreadyEnhanced()
+	status.Enhanced()
+	status.Enhanced()
+	status.Skipped()
+	status.Retried()
+	status.Sourced(SourcedFace{Index: 5, ImageId: "42"})
+
+	snapshot := status.Snapshot(status.StartedAt.Add(time.Hour))
+	if snapshot.Index != 5 || snapshot.ImageId != "42" || snapshot.Enhanced != 2 || snapshot.Skipped != 1 || snapshot.Retried != 1 || snapshot.AlreadyEnhanced != 1 {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+	if snapshot.Throughput != 2 {
+		t.Errorf("Expected 2 images per hour, got %v", snapshot.Throughput)
+	}
+	// 4 attempts in an hour, with 7 faces left including the retry
+	expected := status.StartedAt.Add(time.Hour + 7*15*time.Minute)
+	if snapshot.EstimatedCompletion == nil || !snapshot.EstimatedCompletion.Equal(expected) {
+		t.Errorf("Expected completion at %v, got %v", expected, snapshot.EstimatedCompletion)
+	}
+	if snapshot.NextSlot != nil || snapshot.LastScreenshotAt != nil {
+		t.Errorf("Expected no next slot or screenshot, got %+v", snapshot)
+	}
+
+	var unknown *RunStatus
+	unknown.Enhanced() // A nil status ignores updates
+}
+
+func TestStatusServer(t *testing.T) {
+	status := NewRunStatus("npc enhance-v2")
+	status.SetNextSlot(time.Now().Add(time.Minute))
+	driver := &StatusDriver{
+		ScreenDriver: &MemoryDriver{Width: 1600, Height: 900, Screens: []image.Image{image.NewRGBA(image.Rect(0, 0, 1600, 900))}},
+		Status:       status,
+	}
+	server := httptest.NewServer((&StatusServer{Status: status}).Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/screenshot.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected no screenshot before a capture, got %v", response.Status)
+	}
+
+	driver.CaptureImg()
+	response, err = http.Get(server.URL + "/screenshot.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Expected the last screenshot, got %v %v", response.Status, response.Header.Get("Content-Type"))
+	}
+
+	response, err = http.Get(server.URL + "/status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot StatusSnapshot
+	err = json.NewDecoder(response.Body).Decode(&snapshot)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Command != "npc enhance-v2" || snapshot.NextSlot == nil || snapshot.LastScreenshotAt == nil {
+		t.Errorf("Unexpected status %+v", snapshot)
+	}
+
+	response, err = http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	page, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), `<img src="/screenshot.jpeg"`) {
+		t.Errorf("Expected the status page to show the screenshot, got %s", page)
+	}
+}
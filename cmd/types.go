@@ -8,6 +8,13 @@ type IndexedImage struct {
 	EnhancedImagePath string              `json:"enhancedImagePath"`
 }
 
+// SkippedImage is an image a run decided not to enhance, and why.
+type SkippedImage struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
 type FaceData struct {
 	FaceDetails []types.FaceDetail `json:"FaceDetails"`
 }
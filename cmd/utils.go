@@ -7,7 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/go-vgo/robotgo"
+	"github.com/disintegration/imaging"
 	"github.com/vitali-fedulov/images/v2"
 	"gocv.io/x/gocv"
 )
@@ -62,11 +62,11 @@ func intensifyTextInImage(img image.Image) (image.Image, error) {
 	return nImg, nil
 }
 
-func EnsureChange(changeEvent func()) {
+func EnsureChange(driver ScreenDriver, changeEvent func()) {
 	for {
-		preImg := robotgo.CaptureImg()
+		preImg := driver.CaptureImg()
 		changeEvent()
-		postImg := robotgo.CaptureImg()
+		postImg := driver.CaptureImg()
 		if !imagesSimilar(preImg, postImg) {
 			break
 		}
@@ -79,9 +79,40 @@ func imagesSimilar(imgA, imgB image.Image) bool {
 	return images.Similar(hashA, hashB, imgSizeA, imgSizeB)
 }
 
+// Mean difference of the pixels of two images within a region, from 0 when they are identical to 1.
+// Both regions are scaled down first, so that noise and small shifts count for little.
+func imageDifference(imgA, imgB image.Image, rect image.Rectangle) float64 {
+	const size = 64
+	regionA := imaging.Resize(imaging.Crop(imgA, rect), size, size, imaging.Box)
+	regionB := imaging.Resize(imaging.Crop(imgB, rect), size, size, imaging.Box)
+	total := 0
+	for i := range regionA.Pix {
+		if i%4 == 3 { // Skip alpha
+			continue
+		}
+		diff := int(regionA.Pix[i]) - int(regionB.Pix[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+	return float64(total) / float64(size*size*3*255)
+}
+
 func getFileName(pathToFile string) string {
 	filename := filepath.Base(pathToFile)
 	extension := filepath.Ext(filename)
 	name := filename[0 : len(filename)-len(extension)]
 	return name
 }
+
+// Intersection over union of two rectangles -- 0 when they do not overlap, 1 when they are identical.
+func rectOverlap(a, b image.Rectangle) float64 {
+	intersection := a.Intersect(b)
+	if intersection.Empty() {
+		return 0
+	}
+	intersectionArea := float64(intersection.Dx() * intersection.Dy())
+	unionArea := float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - intersectionArea
+	return intersectionArea / unionArea
+}
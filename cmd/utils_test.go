@@ -0,0 +1,28 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestImageDifference(t *testing.T) {
+	before := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(before, before.Bounds(), &image.Uniform{color.RGBA{120, 90, 80, 255}}, image.Point{}, draw.Src)
+	after := image.NewRGBA(before.Bounds())
+	draw.Draw(after, after.Bounds(), before, image.Point{}, draw.Src)
+	// A dark beard on the left half of the image only
+	beard := image.Rect(20, 40, 80, 90)
+	draw.Draw(after, beard, &image.Uniform{color.RGBA{30, 20, 20, 255}}, image.Point{}, draw.Src)
+
+	if diff := imageDifference(before, before, before.Bounds()); diff != 0 {
+		t.Errorf("Expected no difference between identical images, got %v", diff)
+	}
+	if diff := imageDifference(before, after, image.Rect(0, 0, 100, 100)); diff < enhancementMinChange {
+		t.Errorf("Expected the beard to be a visible change, got %v", diff)
+	}
+	if diff := imageDifference(before, after, image.Rect(100, 0, 200, 100)); diff != 0 {
+		t.Errorf("Expected no difference outside of the beard, got %v", diff)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// FaceApp can take a while to detect the face in a newly selected image.
+	enhancementLoadTimeout = 22 * time.Second
+	filePickerTimeout      = 22 * time.Second
+)
+
+var ErrWaitTimeout = errors.New("Timed out waiting for element")
+
+// WaitResult describes the asset element that appeared on screen, where it appeared and how long it took to appear.
+type WaitResult struct {
+	Element    string
+	Coords     Coords
+	Confidence float32
+	Screen     image.Image
+	Elapsed    time.Duration
+	Polls      int
+}
+
+// Wait for an asset element to appear on screen, checking every interval until the timeout or the context is done.
+func (b *BlueStacks) WaitFor(ctx context.Context, element string, timeout, interval time.Duration) (WaitResult, error) {
+	return b.WaitForAny(ctx, timeout, interval, element)
+}
+
+// Wait for any of the asset elements to appear on screen, checking every interval until the timeout or the context is done.
+// The elements are checked in order against each screenshot, so the first of them on screen is returned.
+func (b *BlueStacks) WaitForAny(ctx context.Context, timeout, interval time.Duration, elements ...string) (WaitResult, error) {
+	if len(elements) == 0 {
+		return WaitResult{}, errors.New("No elements to wait for")
+	}
+	result, err := pollUntil(ctx, timeout, interval, b.Driver.MilliSleep, func() (WaitResult, bool) {
+		screenImg := b.Driver.CaptureImg()
+		for _, element := range elements {
+			coords, confidence, err := b.Locate(element, screenImg)
+			if err == nil {
+				return WaitResult{
+					Element:    element,
+					Coords:     coords,
+					Confidence: confidence,
+					Screen:     screenImg,
+				}, true
+			}
+		}
+		return WaitResult{Screen: screenImg}, false
+	})
+	if debugMode {
+		log.Printf("DEBUG: Waited %v over %d polls for %v - %v\n", result.Elapsed, result.Polls, strings.Join(elements, ", "), err)
+	}
+	if errors.Is(err, ErrWaitTimeout) {
+		return result, fmt.Errorf("%w after %v: %s", err, result.Elapsed, strings.Join(elements, ", "))
+	}
+	return result, err
+}
+
+// Poll until found, checking straight away and then once every interval.
+// The timeout is measured both on the clock and by the intervals slept, so that drivers which do not actually sleep still time out.
+func pollUntil(ctx context.Context, timeout, interval time.Duration, sleep func(ms int), poll func() (WaitResult, bool)) (WaitResult, error) {
+	start := time.Now()
+	var slept time.Duration
+	var result WaitResult
+	for polls := 1; ; polls++ {
+		if err := ctx.Err(); err != nil {
+			result.Elapsed = time.Since(start)
+			return result, err
+		}
+		var found bool
+		result, found = poll()
+		result.Polls = polls
+		elapsed := time.Since(start)
+		if slept > elapsed {
+			elapsed = slept
+		}
+		result.Elapsed = elapsed
+		if found {
+			return result, nil
+		}
+		if elapsed+interval > timeout {
+			return result, ErrWaitTimeout
+		}
+		sleep(int(interval.Milliseconds()))
+		slept += interval
+	}
+}
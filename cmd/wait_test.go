@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPollUntilFound(t *testing.T) {
+	sleeps := 0
+	result, err := pollUntil(context.Background(), 10*time.Second, time.Second, func(ms int) { sleeps++ }, func() (WaitResult, bool) {
+		return WaitResult{Element: "apply"}, sleeps == 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Element != "apply" || result.Polls != 3 || result.Elapsed < 2*time.Second {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestPollUntilTimesOutWithoutSleeping(t *testing.T) {
+	polls := 0
+	result, err := pollUntil(context.Background(), 5*time.Second, 2*time.Second, func(ms int) {}, func() (WaitResult, bool) {
+		polls++
+		return WaitResult{}, false
+	})
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	// Polls at 0s, 2s and 4s -- the next would be past the timeout.
+	if polls != 3 || result.Polls != 3 {
+		t.Errorf("Expected 3 polls, got %d - %+v", polls, result)
+	}
+}
+
+func TestPollUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	polls := 0
+	_, err := pollUntil(ctx, time.Minute, time.Second, func(ms int) { cancel() }, func() (WaitResult, bool) {
+		polls++
+		return WaitResult{}, false
+	})
+	if !errors.Is(err, context.Canceled) || polls != 1 {
+		t.Errorf("Expected the wait to stop once cancelled, got %v after %d polls", err, polls)
+	}
+}